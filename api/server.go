@@ -0,0 +1,231 @@
+// Package api is the bridge's HTTP server: handlers registered by group and
+// listeners that each serve a choice of groups, over TCP or unix sockets,
+// optionally with TLS and client certificates.
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Handler groups a listener can serve.
+const (
+	GroupAPI      = "api"      // SMS submit API.
+	GroupTelegram = "telegram" // Telegram webhook.
+	GroupMetrics  = "metrics"  // Counters on /debug/vars.
+	GroupHealth   = "health"   // Liveness and readiness probes.
+)
+
+var knownGroups = map[string]bool{GroupAPI: true, GroupTelegram: true, GroupMetrics: true, GroupHealth: true}
+
+// Listener is an address the HTTP server listens on and the handler
+// groups served there.
+type Listener struct {
+	Address    string
+	Network    string   // "tcp" (dual-stack, the default), "tcp4", "tcp6" or "unix".
+	Mode       string   // Permissions of a unix socket, octal like "0660".
+	Serve      []string // Handler groups, all of them if empty.
+	Certfile   string   // TLS certificate, the server's one if empty.
+	Keyfile    string
+	Clientca   string // CA bundle to verify client certificates against, no client auth if empty.
+	Clientauth string // "require" (the default) or "optional" client certificates.
+}
+
+type route struct {
+	group   string
+	pattern string
+	handler http.Handler
+}
+
+// Server holds the registered handlers. The zero value is usable.
+type Server struct {
+	Certfile string // TLS certificate of listeners without their own, plain HTTP if empty.
+	Keyfile  string
+
+	// Guard wraps every handler of the API group, for authentication
+	// and load shedding. Handlers are served as is if nil.
+	Guard func(http.Handler) http.Handler
+
+	routes []route
+}
+
+// NewServer returns a Server with the expvar counters registered in the
+// metrics group.
+func NewServer() *Server {
+	s := new(Server)
+	s.Handle(GroupMetrics, "/debug/vars", expvar.Handler())
+	return s
+}
+
+// Handle registers a handler for pattern in a handler group.
+func (s *Server) Handle(group, pattern string, handler http.Handler) {
+	s.routes = append(s.routes, route{group, pattern, handler})
+}
+
+// ShutdownTimeout is how long Serve waits for requests in flight when it
+// stops.
+const ShutdownTimeout = 10 * time.Second
+
+// Serve starts all listeners and blocks until one of them fails or ctx is
+// done, then shuts the others down, closing connections still busy after
+// ShutdownTimeout.
+func (s *Server) Serve(ctx context.Context, listeners []Listener) error {
+	errc := make(chan error, len(listeners))
+	var servers []*http.Server
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		for _, srv := range servers {
+			if err := srv.Shutdown(ctx); err != nil {
+				srv.Close()
+			}
+		}
+	}()
+	for _, l := range listeners {
+		mux, err := s.mux(l)
+		if err != nil {
+			return err
+		}
+		network := l.Network
+		if network == "" {
+			network = "tcp"
+		}
+		var ln net.Listener
+		if network == "unix" {
+			ln, err = listenUnix(l.Address, l.Mode)
+		} else {
+			ln, err = net.Listen(network, l.Address)
+		}
+		if err != nil {
+			return err
+		}
+		cert, key := l.Certfile, l.Keyfile
+		if cert == "" {
+			cert, key = s.Certfile, s.Keyfile
+		}
+		log.Printf("Listening on %s %s for %v", network, ln.Addr(), s.groups(l))
+		srv := &http.Server{Handler: mux}
+		if l.Clientca != "" {
+			if cert == "" {
+				ln.Close()
+				return fmt.Errorf("listener %s: client certificates need TLS", l.Address)
+			}
+			srv.TLSConfig, err = clientTLS(l.Clientca, l.Clientauth)
+			if err != nil {
+				ln.Close()
+				return fmt.Errorf("listener %s: %w", l.Address, err)
+			}
+		}
+		servers = append(servers, srv)
+		go func(ln net.Listener) {
+			if cert != "" {
+				errc <- srv.ServeTLS(ln, cert, key)
+			} else {
+				errc <- srv.Serve(ln)
+			}
+		}(ln)
+	}
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// groups returns the handler groups l serves.
+func (s *Server) groups(l Listener) []string {
+	if len(l.Serve) > 0 {
+		return l.Serve
+	}
+	seen := make(map[string]bool)
+	var all []string
+	for _, r := range s.routes {
+		if !seen[r.group] {
+			seen[r.group] = true
+			all = append(all, r.group)
+		}
+	}
+	sort.Strings(all)
+	return all
+}
+
+func (s *Server) mux(l Listener) (*http.ServeMux, error) {
+	mux := http.NewServeMux()
+	for _, g := range s.groups(l) {
+		if !knownGroups[g] {
+			return nil, fmt.Errorf("listener %s: unknown handler group %q", l.Address, g)
+		}
+		for _, r := range s.routes {
+			switch {
+			case r.group != g:
+			case g == GroupAPI && s.Guard != nil:
+				mux.Handle(r.pattern, s.Guard(r.handler))
+			default:
+				mux.Handle(r.pattern, r.handler)
+			}
+		}
+	}
+	return mux, nil
+}
+
+// listenUnix listens on a unix socket at path, replacing a stale socket
+// left by a previous run, and applies mode to it.
+func listenUnix(path, mode string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != "" {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err == nil {
+			err = os.Chmod(path, os.FileMode(perm))
+		}
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("can't set mode %q on %s: %w", mode, path, err)
+		}
+	}
+	return ln, nil
+}
+
+// clientTLS returns the TLS config of a listener that verifies client
+// certificates against the CA bundle in cafile. With mode "optional"
+// clients without a certificate are let through to use API keys.
+func clientTLS(cafile, mode string) (*tls.Config, error) {
+	pem, err := os.ReadFile(cafile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", cafile)
+	}
+	c := &tls.Config{ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	switch mode {
+	case "", "require":
+	case "optional":
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("unknown client auth mode %q", mode)
+	}
+	return c, nil
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// unixClient makes requests to the unix socket at path.
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func TestServe(t *testing.T) {
+	dir := t.TempDir()
+	apiSock, healthSock, allSock := filepath.Join(dir, "api"), filepath.Join(dir, "health"), filepath.Join(dir, "all")
+	// A socket left by an earlier run is replaced.
+	stale, err := net.Listen("unix", allSock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	s := new(Server)
+	s.Guard = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Guarded", "yes")
+			h.ServeHTTP(w, r)
+		})
+	}
+	ok := func(body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, body) })
+	}
+	s.Handle(GroupAPI, "/send", ok("sent"))
+	s.Handle(GroupHealth, "/healthz", ok("ok"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(ctx, []Listener{
+			{Address: apiSock, Network: "unix", Mode: "0600", Serve: []string{GroupAPI}},
+			{Address: healthSock, Network: "unix", Serve: []string{GroupHealth}},
+			{Address: allSock, Network: "unix"},
+		})
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %s", err)
+		}
+	}()
+
+	tests := []struct {
+		sock, path string
+		status     int
+		guarded    bool
+	}{
+		{apiSock, "/send", http.StatusOK, true},
+		{apiSock, "/healthz", http.StatusNotFound, false},
+		{healthSock, "/healthz", http.StatusOK, false},
+		{healthSock, "/send", http.StatusNotFound, false},
+		{allSock, "/send", http.StatusOK, true},
+		{allSock, "/healthz", http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		var resp *http.Response
+		for deadline := time.Now().Add(5 * time.Second); ; {
+			if resp, err = unixClient(tt.sock).Get("http://api" + tt.path); err == nil || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("GET %s on %s: %s", tt.path, filepath.Base(tt.sock), err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || (resp.Header.Get("X-Guarded") != "") != tt.guarded {
+			t.Errorf("GET %s on %s: got %s, guarded %q, want %d, guarded %v",
+				tt.path, filepath.Base(tt.sock), resp.Status, resp.Header.Get("X-Guarded"), tt.status, tt.guarded)
+		}
+	}
+	if fi, err := os.Stat(apiSock); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("Got socket %v, %v, want mode 0600", fi, err)
+	}
+}
+
+func TestServeErrors(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		l    Listener
+	}{
+		{"unknown group", Listener{Address: filepath.Join(dir, "a"), Network: "unix", Serve: []string{"admin"}}},
+		{"not a socket", Listener{Address: file, Network: "unix"}},
+		{"bad mode", Listener{Address: filepath.Join(dir, "b"), Network: "unix", Mode: "rw"}},
+		{"client certificates without TLS", Listener{Address: filepath.Join(dir, "c"), Network: "unix", Clientca: "ca.pem"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := NewServer().Serve(ctx, []Listener{tt.l}); err == nil {
+				t.Error("Got no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/time/rate"
+
+	"telegram-smpp-bot/api"
+	"telegram-smpp-bot/telegramsink"
+)
+
+// routeDisabled reports whether the route for prefix is disabled.
+func (b *Bridge) routeDisabled(prefix string) bool {
+	b.disabledRoutes.RLock()
+	defer b.disabledRoutes.RUnlock()
+	return b.disabledRoutes.m[strings.TrimPrefix(prefix, "+")]
+}
+
+// setRouteEnabled enables or disables the routes for prefix, both in the
+// config and in the route table.
+func (b *Bridge) setRouteEnabled(prefix string, enabled bool) error {
+	prefix = strings.TrimPrefix(prefix, "+")
+	known := false
+	for _, r := range b.config.Routes {
+		known = known || strings.TrimPrefix(r.Prefix, "+") == prefix
+	}
+	if rs := b.tariffs.Load(); rs != nil {
+		for _, r := range *rs {
+			known = known || r.prefix == prefix
+		}
+	}
+	if !known {
+		return fmt.Errorf("no route for prefix %q", prefix)
+	}
+	b.disabledRoutes.Lock()
+	defer b.disabledRoutes.Unlock()
+	if enabled {
+		delete(b.disabledRoutes.m, prefix)
+	} else {
+		b.disabledRoutes.m[prefix] = true
+	}
+	log.Printf("Route %q enabled: %t", prefix, enabled)
+	return nil
+}
+
+// setRate changes the global submit rate, or that of the scoped limit
+// named scope, to r per second.
+func (b *Bridge) setRate(scope string, r float64) error {
+	// NaN fails every comparison, so it is caught by asking for r > 0.
+	if !(r > 0) || math.IsInf(r, 0) {
+		return fmt.Errorf("rate must be a positive number")
+	}
+	if scope == "" || scope == "global" {
+		b.limiter.SetLimit(rate.Limit(r))
+		log.Printf("Global rate limit set to %g/s", r)
+		return nil
+	}
+	for _, l := range b.scopedLimiters {
+		if l.Name == scope {
+			l.SetLimit(rate.Limit(r))
+			log.Printf("Rate limit %s set to %g/s", scope, r)
+			return nil
+		}
+	}
+	return fmt.Errorf("no rate limit named %q", scope)
+}
+
+// setDebugLevel changes the debug level, including the request logging
+// of the Telegram client.
+func (b *Bridge) setDebugLevel(level int) {
+	b.debugLevel.Store(int64(level))
+	if c, ok := b.tg.(*telegramsink.Client); ok {
+		c.Debug.Store(level < 3)
+	}
+}
+
+// setPaused pauses or resumes forwarding to Telegram.
+func (b *Bridge) setPaused(paused bool) {
+	b.forwardingPaused.Store(paused)
+	log.Printf("Forwarding paused: %t", paused)
+}
+
+// settings is the runtime state reported by GET /api/v2/admin/settings.
+type settings struct {
+	Debug          int                `json:"debug"`
+	Rate           float64            `json:"rate"`
+	Rates          map[string]float64 `json:"rates,omitempty"` // By scope name.
+	Paused         bool               `json:"paused"`
+	DisabledRoutes []string           `json:"disabled_routes"`
+}
+
+func (b *Bridge) currentSettings() settings {
+	s := settings{
+		Debug:          int(b.debugLevel.Load()),
+		Rate:           float64(b.limiter.Limit()),
+		Paused:         b.forwardingPaused.Load(),
+		DisabledRoutes: []string{},
+	}
+	for _, l := range b.scopedLimiters {
+		if s.Rates == nil {
+			s.Rates = make(map[string]float64)
+		}
+		s.Rates[l.Name] = float64(l.Limit())
+	}
+	b.disabledRoutes.RLock()
+	for p := range b.disabledRoutes.m {
+		s.DisabledRoutes = append(s.DisabledRoutes, "+"+p)
+	}
+	b.disabledRoutes.RUnlock()
+	sort.Strings(s.DisabledRoutes)
+	return s
+}
+
+func (b *Bridge) registerAdmin() {
+	// The admin endpoints, for admin tenants only. Each change is
+	// audited and answered with the settings after it.
+	//
+	//	GET  /api/v2/admin/settings
+	//	POST /api/v2/admin/debug   level=N
+	//	POST /api/v2/admin/rate    rate=N, scope=name for a scoped limit
+	//	POST /api/v2/admin/pause
+	//	POST /api/v2/admin/resume
+	//	POST /api/v2/admin/routes  prefix=+49&enabled=false
+	b.handle(api.GroupAPI, "/api/v2/admin/", func(w http.ResponseWriter, r *http.Request) {
+		if !b.isAdmin(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		action := strings.TrimPrefix(r.URL.Path, "/api/v2/admin/")
+		if action == "settings" {
+			if r.Method != http.MethodGet {
+				w.Header().Set("Allow", http.MethodGet)
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			b.writeSettings(w)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var subject string
+		var err error
+		switch action {
+		case "debug":
+			subject = r.FormValue("level")
+			var level int
+			if level, err = strconv.Atoi(subject); err == nil {
+				b.setDebugLevel(level)
+				log.Printf("Debug level set to %d", level)
+			}
+		case "rate":
+			scope := r.FormValue("scope")
+			subject = strings.TrimPrefix(scope+"="+r.FormValue("rate"), "=")
+			var n float64
+			if n, err = strconv.ParseFloat(r.FormValue("rate"), 64); err == nil {
+				err = b.setRate(scope, n)
+			}
+		case "pause", "resume":
+			b.setPaused(action == "pause")
+		case "routes":
+			var enabled bool
+			if enabled, err = strconv.ParseBool(r.FormValue("enabled")); err == nil {
+				subject = r.FormValue("prefix") + " enabled=" + strconv.FormatBool(enabled)
+				err = b.setRouteEnabled(r.FormValue("prefix"), enabled)
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		b.audit(r, action, subject, 0, err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		b.writeSettings(w)
+	})
+}
+
+func (b *Bridge) writeSettings(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b.currentSettings())
+}
+
+// cmdDebug sets the debug level: "on" logs the messages, "off" stops.
+func (b *Bridge) cmdDebug(ctx context.Context, msg *telegramsink.Message, args string) {
+	var level int
+	var err error
+	switch args = strings.TrimSpace(args); args {
+	case "on":
+		level = 1
+	case "off":
+		level = 3
+	default:
+		if level, err = strconv.Atoi(args); err != nil {
+			b.reply(ctx, msg, fmt.Sprintf("Usage: /debug on|off|level, now %d.", b.debugLevel.Load()), nil)
+			return
+		}
+	}
+	b.setDebugLevel(level)
+	log.Printf("User %d set the debug level to %d", msg.From.ID, level)
+	b.auditUser(msg.From.ID, "debug", strconv.Itoa(level), nil)
+	b.reply(ctx, msg, fmt.Sprintf("Debug level %d.", level), nil)
+}
+
+func (b *Bridge) cmdPause(ctx context.Context, msg *telegramsink.Message, _ string) {
+	b.setPaused(true)
+	b.auditUser(msg.From.ID, "pause", "", nil)
+	b.reply(ctx, msg, "⏸ Forwarding paused. SMS are stored, /replay them after /resume.", nil)
+}
+
+func (b *Bridge) cmdResume(ctx context.Context, msg *telegramsink.Message, _ string) {
+	b.setPaused(false)
+	b.auditUser(msg.From.ID, "resume", "", nil)
+	b.reply(ctx, msg, "▶️ Forwarding resumed.", nil)
+}
+
+// cmdRate sets the global submit rate, or that of a scoped limit.
+func (b *Bridge) cmdRate(ctx context.Context, msg *telegramsink.Message, args string) {
+	f := strings.Fields(args)
+	if len(f) == 0 || len(f) > 2 {
+		b.reply(ctx, msg, fmt.Sprintf("Usage: /rate N or /rate scope N, now %g/s.", float64(b.limiter.Limit())), nil)
+		return
+	}
+	var scope string
+	if len(f) == 2 {
+		scope = f[0]
+	}
+	n, err := strconv.ParseFloat(f[len(f)-1], 64)
+	if err == nil {
+		err = b.setRate(scope, n)
+	}
+	b.auditUser(msg.From.ID, "rate", strings.TrimPrefix(scope+"="+f[len(f)-1], "="), err)
+	if err != nil {
+		b.reply(ctx, msg, html.EscapeString(err.Error()), nil)
+		return
+	}
+	b.reply(ctx, msg, fmt.Sprintf("Rate set to %g/s.", n), nil)
+}
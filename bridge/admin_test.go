@@ -0,0 +1,38 @@
+package bridge
+
+import (
+	"math"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestSetRate(t *testing.T) {
+	tests := []struct {
+		r  float64
+		ok bool
+	}{
+		{5, true},
+		{0.5, true},
+		{0, false},
+		{-1, false},
+		{math.NaN(), false},
+		{math.Inf(1), false},
+		{math.Inf(-1), false},
+	}
+	for _, tt := range tests {
+		b := New(&Config{})
+		before := b.limiter.Limit()
+		err := b.setRate("global", tt.r)
+		if (err == nil) != tt.ok {
+			t.Errorf("setRate(%g) = %v, want ok %v", tt.r, err, tt.ok)
+		}
+		want := before
+		if tt.ok {
+			want = rate.Limit(tt.r)
+		}
+		if got := b.limiter.Limit(); got != want {
+			t.Errorf("setRate(%g) set the limit to %g, want %g", tt.r, got, want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package bridge
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alertWindows are the open throttling windows by alert class.
+type alertWindows struct {
+	sync.Mutex
+	m map[string]*alertWindow
+}
+
+// alertWindow collects repeats of an alert class after the first
+// notification was posted.
+type alertWindow struct {
+	count int    // Repeats since the last notification.
+	last  string // Text of the latest repeat.
+}
+
+// alert posts an error notification to the ops chat. The first error of a
+// class is posted immediately; repeats within the class window are counted
+// and posted as one summary when the window closes. Classes look like
+// "telegram:401" or "smpp:bind"; the part before the colon selects the
+// window if the full class has none configured.
+func (b *Bridge) alert(class, m string) {
+	b.alerts.Lock()
+	defer b.alerts.Unlock()
+
+	if w, ok := b.alerts.m[class]; ok {
+		w.count++
+		w.last = m
+		return
+	}
+	b.alerts.m[class] = &alertWindow{}
+	window := b.alertWindowFor(class)
+	time.AfterFunc(window, func() { b.flushAlert(class, window) })
+	go b.sendOps("❗ " + html.EscapeString(m))
+}
+
+// flushAlert posts the summary of a window. The window stays open while
+// repeats keep coming and closes silently after a quiet one.
+func (b *Bridge) flushAlert(class string, window time.Duration) {
+	b.alerts.Lock()
+	defer b.alerts.Unlock()
+
+	w := b.alerts.m[class]
+	if w.count == 0 {
+		delete(b.alerts.m, class)
+		return
+	}
+	m := fmt.Sprintf("❗ error %s occurred %d times in the last %s, last one: %s", class, w.count, formatPeriod(window), w.last)
+	w.count = 0
+	time.AfterFunc(window, func() { b.flushAlert(class, window) })
+	go b.sendOps(html.EscapeString(m))
+}
+
+func (b *Bridge) alertWindowFor(class string) time.Duration {
+	if d, ok := b.config.Alertwindows[class]; ok {
+		return d.Duration
+	}
+	if prefix, _, ok := strings.Cut(class, ":"); ok {
+		if d, ok := b.config.Alertwindows[prefix]; ok {
+			return d.Duration
+		}
+	}
+	return b.config.Alertwindow.Duration
+}
@@ -0,0 +1,65 @@
+package bridge
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// auditRecord is a line of the audit log.
+type auditRecord struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Subject string    `json:"subject"` // Number or messages the action was about.
+	Tenant  string    `json:"tenant,omitempty"`
+	User    int64     `json:"user,omitempty"` // Telegram user of a bot command.
+	IP      string    `json:"ip,omitempty"`
+	Count   int       `json:"count"` // Messages affected.
+	Error   string    `json:"error,omitempty"`
+}
+
+// audit appends a record of an action taken for r to config.Auditlog, or
+// to the log if there is none.
+func (b *Bridge) audit(r *http.Request, action, subject string, count int, err error) {
+	rec := auditRecord{Time: time.Now(), Action: action, Subject: subject, IP: b.clientIP(r).String(), Count: count}
+	if id := identityOf(r); id != nil {
+		rec.Tenant = id.Tenant
+	}
+	b.writeAudit(rec, err)
+}
+
+// auditUser is audit for an action taken by a bot command of user.
+func (b *Bridge) auditUser(user int64, action, subject string, err error) {
+	b.writeAudit(auditRecord{Time: time.Now(), Action: action, Subject: subject, User: user}, err)
+}
+
+func (b *Bridge) writeAudit(rec auditRecord, err error) {
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	line, _ := json.Marshal(rec)
+	// Records that end up in the log have the number masked like the
+	// rest of it.
+	logged := rec
+	logged.Subject = b.mask(rec.Subject)
+	logLine, _ := json.Marshal(logged)
+	if b.config.Auditlog == "" {
+		log.Printf("Audit: %s", logLine)
+		return
+	}
+	b.auditMu.Lock()
+	defer b.auditMu.Unlock()
+	f, err := os.OpenFile(b.config.Auditlog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		log.Printf("Can't write audit log. Error: %s. Audit: %s", err, logLine)
+		errsTotal.Add(1)
+	}
+}
@@ -0,0 +1,26 @@
+package bridge
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAuditMasksLog(t *testing.T) {
+	const number = "+4915112345678"
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	for _, auditlog := range []string{"", dir} { // No audit log, and one that can't be written.
+		buf.Reset()
+		b := New(&Config{Masknumbers: true, Auditlog: auditlog})
+		b.auditUser(testAdmin, "export", number, nil)
+		if got := buf.String(); !strings.Contains(got, `"subject":"+4915•••••••78"`) {
+			t.Errorf("Audit log %q: got log %q, want the number masked", auditlog, got)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package bridge
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Tenant is an API identity and the credentials that prove it.
+type Tenant struct {
+	Apikeys   []string // Keys sent as X-API-Key or as a bearer token.
+	Certnames []string // Client certificate common names or SANs (DNS, email, URI).
+	Sources   []string // Source addresses the tenant may submit from, any if empty.
+	Privacy   bool     // May export and delete the messages of any number.
+	Admin     bool     // May replay messages and change runtime settings.
+	Subjects  []string // JWT "sub" claims that may name the tenant, required for Privacy and Admin ones.
+}
+
+// allowsSubject reports whether a JWT with the subject sub may name t.
+func (t Tenant) allowsSubject(sub string) bool {
+	for _, s := range t.Subjects {
+		if sub != "" && s == sub {
+			return true
+		}
+	}
+	return false
+}
+
+// identity is the authenticated caller of an API request.
+type identity struct {
+	Tenant  string
+	Sources []string
+}
+
+func (id *identity) allowsSource(src string) bool {
+	if len(id.Sources) == 0 {
+		return true
+	}
+	for _, s := range id.Sources {
+		if s == src {
+			return true
+		}
+	}
+	return false
+}
+
+type ctxKey int
+
+const identityKey ctxKey = 0
+
+// identityOf returns the caller of an authenticated request, nil when
+// the API is open.
+func identityOf(r *http.Request) *identity {
+	id, _ := r.Context().Value(identityKey).(*identity)
+	return id
+}
+
+// authRequired reports whether API callers must authenticate, which is
+// when any tenant or a JWT issuer is configured.
+func (b *Bridge) authRequired() bool {
+	return len(b.config.Tenants) > 0 || b.config.Jwt.Jwksurl != ""
+}
+
+// authenticate finds the tenant of a request by its verified client
+// certificate, its JWT or its API key.
+func (b *Bridge) authenticate(r *http.Request) (*identity, bool) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		names := certNames(r.TLS.VerifiedChains[0][0])
+		for name, t := range b.config.Tenants {
+			for _, want := range t.Certnames {
+				if names[want] {
+					return &identity{Tenant: name, Sources: t.Sources}, true
+				}
+			}
+		}
+	}
+	key := r.Header.Get("X-API-Key")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && key == "" {
+		key = bearer
+	}
+	if key != "" && b.config.Jwt.Jwksurl != "" && looksLikeJWT(key) {
+		id, err := b.verifyJWT(key)
+		if err != nil {
+			log.Printf("Rejected JWT from %s. Error: %s", b.clientIP(r), err)
+			return nil, false
+		}
+		return id, true
+	}
+	if key != "" {
+		for name, t := range b.config.Tenants {
+			for _, k := range t.Apikeys {
+				if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+					return &identity{Tenant: name, Sources: t.Sources}, true
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+// certNames returns the common name and the SANs of a certificate.
+func certNames(c *x509.Certificate) map[string]bool {
+	names := map[string]bool{}
+	if c.Subject.CommonName != "" {
+		names[c.Subject.CommonName] = true
+	}
+	for _, n := range c.DNSNames {
+		names[n] = true
+	}
+	for _, n := range c.EmailAddresses {
+		names[n] = true
+	}
+	for _, u := range c.URIs {
+		names[u.String()] = true
+	}
+	return names
+}
+
+// requireAuth rejects unauthenticated requests when tenants are
+// configured and passes the caller's identity on in the context.
+func (b *Bridge) requireAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !b.authRequired() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		id, ok := b.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="telegram-smpp-bot"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey, id)))
+	})
+}
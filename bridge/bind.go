@@ -0,0 +1,191 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/fiorix/go-smpp/smpp"
+
+	"telegram-smpp-bot/smppclient"
+)
+
+// Smsc is an SMPP account to bind with.
+type Smsc struct {
+	Name       string
+	Address    string // host:port, or an SRV name like "_smpp._tcp.example.com".
+	Username   string
+	Password   string
+	Windowsize uint
+}
+
+// smsc is the bind to one SMSC and what is needed to recreate it.
+type smsc struct {
+	Smsc
+	b       *Bridge
+	watcher *bindWatcher
+
+	mu      sync.RWMutex
+	tx      smppclient.Transceiver
+	targets []string // SMSC addresses from the last discovery.
+	target  int      // Index of the address in use.
+}
+
+// initBinds sets up the configured SMSCs, or the one in config.Smpp,
+// passing incoming PDUs to handler. Nothing is bound yet.
+func (b *Bridge) initBinds(handler smpp.HandlerFunc) error {
+	b.txHandler = handler
+	cs := b.config.Smscs
+	if len(cs) == 0 {
+		cs = []Smsc{{Name: b.config.Smpp, Address: b.config.Smpp, Username: b.config.Username, Password: b.config.Password, Windowsize: b.config.Windowsize}}
+	}
+	for _, c := range cs {
+		if c.Name == "" || b.smscNamed[c.Name] != nil {
+			return fmt.Errorf("SMSC %s needs a unique name", c.Address)
+		}
+		s := &smsc{Smsc: c, b: b, watcher: b.newBindWatcher(c.Name), target: -1}
+		b.smscs = append(b.smscs, s)
+		b.smscNamed[c.Name] = s
+	}
+	if err := b.initRoutes(); err != nil {
+		return err
+	}
+	return b.initRatelimits()
+}
+
+// bindAll connects to every SMSC.
+func (b *Bridge) bindAll() {
+	for _, s := range b.smscs {
+		s.connect()
+	}
+}
+
+// unbindAll closes every bind without connecting again.
+func (b *Bridge) unbindAll() {
+	for _, s := range b.smscs {
+		s.unbind()
+	}
+}
+
+// rebindAll closes every bind and connects again.
+func (b *Bridge) rebindAll() {
+	for _, s := range b.smscs {
+		s.rebind()
+	}
+}
+
+// connect creates a persistent connection to the next SMSC address and
+// makes it the current bind. Addresses are discovered again once all of
+// them were tried, so each round picks up DNS changes.
+func (s *smsc) connect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.target++
+	if s.target >= len(s.targets) {
+		t, err := smppclient.Targets(s.b.runCtx, s.b.resolver, s.Address)
+		if err != nil {
+			log.Printf("Can't resolve SMSC address %s. Error: %s", s.Address, err)
+			t = []string{s.Address}
+		}
+		s.targets, s.target = t, 0
+	}
+	addr := s.targets[s.target]
+	log.Printf("Binding to SMSC %s at %s (%d of %d)", s.Name, addr, s.target+1, len(s.targets))
+	t := s.b.newTransceiver(smppclient.Params{
+		Addr:       addr,
+		User:       s.Username,
+		Passwd:     s.Password,
+		Handler:    s.b.txHandler, // Handle incoming SM or delivery receipts.
+		WindowSize: s.Windowsize,  // Rate limiting is done by submit.
+	})
+	conn := t.Bind()
+	s.tx = t
+	go supervise(s.b.runCtx, "smpp status watcher", func(context.Context) { s.watch(t, addr, conn) })
+}
+
+// watch reports the status of bind t to addr until it is closed and moves
+// on to the next SMSC address after config.Failoverafter failed attempts
+// in a row.
+func (s *smsc) watch(t smppclient.Transceiver, addr string, conn <-chan smpp.ConnStatus) {
+	failures := 0
+	for c := range conn {
+		log.Printf("SMPP connection status of %s: %q", s.Name, c.Status())
+		s.watcher.update(c)
+		switch c.Status() {
+		case smpp.Connected:
+			failures = 0
+		case smpp.ConnectionFailed, smpp.BindFailed:
+			failures++
+			if failures == s.b.config.Failoverafter {
+				go s.failover(t, addr)
+			}
+		}
+	}
+}
+
+// failover replaces bind t to addr, if it is still the current one, with
+// a bind to the next address.
+func (s *smsc) failover(t smppclient.Transceiver, addr string) {
+	if s.current() != t {
+		return
+	}
+	log.Printf("Giving up on SMSC address %s after %d failures", addr, s.b.config.Failoverafter)
+	if err := t.Close(); err != nil {
+		log.Printf("Can't close SMPP bind. Error: %s", err)
+	}
+	s.connect()
+}
+
+// rebind closes the current bind and connects again.
+func (s *smsc) rebind() {
+	old := s.current()
+	if old == nil {
+		return
+	}
+	if err := old.Close(); err != nil {
+		log.Printf("Can't close SMPP bind. Error: %s", err)
+	}
+	s.connect()
+}
+
+// unbind closes the current bind without connecting again.
+func (s *smsc) unbind() {
+	s.mu.Lock()
+	old := s.tx
+	s.tx = nil
+	s.mu.Unlock()
+	if old == nil {
+		return
+	}
+	if err := old.Close(); err != nil {
+		log.Printf("Can't close SMPP bind. Error: %s", err)
+	}
+}
+
+// current returns the bind to submit with, nil on an HA follower.
+func (s *smsc) current() smppclient.Transceiver {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tx
+}
+
+// anyUp reports whether at least one SMSC bind is up.
+func (b *Bridge) anyUp() bool {
+	for _, s := range b.smscs {
+		if s.watcher.isUp() {
+			return true
+		}
+	}
+	return false
+}
+
+// smscNames returns the names of all SMSCs.
+func (b *Bridge) smscNames() []string {
+	names := make([]string, 0, len(b.smscs))
+	for _, s := range b.smscs {
+		names = append(names, s.Name)
+	}
+	return names
+}
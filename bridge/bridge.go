@@ -0,0 +1,390 @@
+// Package bridge forwards SMS between SMPP centers and Telegram chats and
+// serves the HTTP submit API. Embed it with New(cfg).Run(ctx).
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fiorix/go-smpp/smpp"
+	"github.com/fiorix/go-smpp/smpp/pdu"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdufield"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdutlv"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+	"golang.org/x/time/rate"
+
+	"telegram-smpp-bot/api"
+	"telegram-smpp-bot/smppclient"
+	"telegram-smpp-bot/telegramsink"
+)
+
+// Bridge is a configured bridge with its own config, binds and HTTP
+// server. The traffic counters on /debug/vars and in /stats belong to the
+// process, so they only describe a bridge that runs alone.
+type Bridge struct {
+	// SMPP makes the transceiver of each bind, smppclient.New if nil.
+	SMPP func(smppclient.Params) smppclient.Transceiver
+	// Telegram makes the Bot API calls, a telegramsink.Client set up
+	// from the config if nil.
+	Telegram telegramsink.Sender
+
+	config *Config
+	// runCtx is the context of Run. Work done for no caller in
+	// particular, like notifications, callbacks and the handling of
+	// incoming PDUs, derives from it, so shutdown cancels it.
+	runCtx context.Context
+	// server is the HTTP server all handlers are registered with.
+	server   *api.Server
+	commands []command
+
+	// SMPP side. newTransceiver makes the transceivers of binds.
+	newTransceiver func(smppclient.Params) smppclient.Transceiver
+	txHandler      smpp.HandlerFunc
+	smscs          []*smsc // In config order.
+	smscNamed      map[string]*smsc
+	// leader is set while this instance holds the lease. Without HA it
+	// is always set.
+	leader atomic.Bool
+	// resolver looks up the Telegram and SMSC hostnames through the
+	// configured DNS servers and caches the answers.
+	resolver *dnsCache
+	// state holds dedup and reassembly state, shared with the other
+	// instances through Redis if configured.
+	state sharedState
+	// inboundQueues decouple the SMPP read loop from Telegram: the PDU
+	// handler only decodes and queues, config.Inboundworkers workers
+	// forward. All workers share one queue, unless config.Inboundordered
+	// gives each its own so that a sender's messages are forwarded one
+	// after another.
+	inboundQueues []chan inbound
+	// capture receives the PDUs taken from the SMSCs, see initCapture.
+	capture captureFile
+
+	// Outbound pipeline: a slot per submit waiting for the rate limiter
+	// or the SMPP window, the submits that can still be cancelled, the
+	// limiters, routes and quotas.
+	submitSlots    chan struct{}
+	waiting        waitingSubmits
+	limiter        *rate.Limiter
+	scopedLimiters []*scopedLimiter
+	// tariffs is the least-cost routing table loaded from
+	// config.Routefile. It takes precedence over config.Routes while it
+	// has rows.
+	tariffs atomic.Pointer[[]tariff]
+	quotaMu sync.Mutex
+	quotas  []*quotaState
+	lookups lookupCache
+	spend   spendToday
+	// numbering maps E.164 prefixes without "+" to networks.
+	numbering map[string]network
+
+	// Telegram side. tg makes all Bot API calls.
+	tg      telegramsink.Sender
+	alerts  alertWindows
+	wizards wizardMap
+	// keywordRes are the moderation keywords, compiled.
+	keywordRes []*regexp.Regexp
+
+	// HTTP side. The proxies, allowlist and per-IP limits are set up by
+	// initClientIP.
+	trustedProxies []netip.Prefix
+	allowedIPs     []netip.Prefix
+	ipLimiters     *limiterMap
+	jwks           jwkSet
+	jwksClient     *http.Client
+	callbackClient *http.Client
+	// serviceClient calls the lookup, moderation and translation
+	// services, see initServiceClient.
+	serviceClient *http.Client
+
+	store   *Store
+	auditMu sync.Mutex
+	cdrs    struct {
+		sync.Mutex
+		f    *os.File
+		name string
+	}
+
+	// Settings that admins change at runtime, without a restart dropping
+	// the binds. They start from the config and are lost on restart.
+	// debugLevel is config.Debug, lower logs more. forwardingPaused stops
+	// inbound SMS and receipts from being posted to Telegram; they are
+	// still stored and posted to the callback, the SMS for /replay.
+	// disabledRoutes holds the prefixes, without "+", of the
+	// routes that routeFor passes over.
+	debugLevel       atomic.Int64
+	forwardingPaused atomic.Bool
+	disabledRoutes   struct {
+		sync.RWMutex
+		m map[string]bool
+	}
+}
+
+// New returns a bridge for cfg with its HTTP handlers and bot commands
+// registered. Nothing is started until Run.
+func New(cfg *Config) *Bridge {
+	b := &Bridge{
+		config:         cfg,
+		runCtx:         context.Background(),
+		server:         api.NewServer(),
+		newTransceiver: smppclient.New,
+		smscNamed:      make(map[string]*smsc),
+		limiter:        rate.NewLimiter(rate.Limit(10), 1), // Max rate of 10/s.
+		lookups:        lookupCache{m: make(map[string]lookupEntry)},
+		spend:          spendToday{byTenant: make(map[string]float64)},
+		alerts:         alertWindows{m: make(map[string]*alertWindow)},
+		wizards:        wizardMap{m: make(map[wizardKey]*wizard)},
+		jwksClient:     &http.Client{Timeout: 10 * time.Second},
+		// Callback posts go out on their own client, they don't need
+		// the Telegram proxy settings.
+		callbackClient: &http.Client{Timeout: 10 * time.Second},
+		serviceClient:  &http.Client{Timeout: 10 * time.Second},
+	}
+	b.disabledRoutes.m = make(map[string]bool)
+	b.commands = b.commandTable()
+	for _, register := range []func(){b.registerSubmit, b.registerHealth, b.registerLookup, b.registerExport, b.registerPrivacy, b.registerReplay, b.registerCancel, b.registerAdmin} {
+		register()
+	}
+	return b
+}
+
+// running holds the bridges inside Run, for the metrics that add up
+// their state.
+var running = struct {
+	sync.Mutex
+	m map[*Bridge]bool
+}{m: make(map[*Bridge]bool)}
+
+// eachRunning calls fn for every running bridge.
+func eachRunning(fn func(b *Bridge)) {
+	running.Lock()
+	defer running.Unlock()
+	for b := range running.m {
+		fn(b)
+	}
+}
+
+// Run fills in the defaults of the config, binds to the SMSCs, starts the
+// Telegram side and serves HTTP until a listener fails or ctx is done.
+// Errors in the config are returned before anything is started. When Run
+// returns, the background work is cancelled and the binds are closed.
+func (b *Bridge) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer b.unbindAll()
+	b.runCtx = ctx
+	running.Lock()
+	running.m[b] = true
+	running.Unlock()
+	defer func() {
+		running.Lock()
+		delete(running.m, b)
+		running.Unlock()
+	}()
+	b.setDefaults()
+	b.debugLevel.Store(int64(b.config.Debug))
+	log.Printf("Program name: %s, bot ID: %s, Chat ID: %s, Listen address: %s, SMPP address: %s", b.config.Name, b.config.Botid, b.config.Chatid, b.config.Address, b.config.Smpp)
+
+	b.initResolver()
+	for _, init := range []func() error{b.initTelegramClient, b.initServiceClient, b.initClientIP, b.initNumbering, b.initCDR, b.initModeration, b.initCapture} {
+		if err := init(); err != nil {
+			return err
+		}
+	}
+	if b.Telegram != nil {
+		b.tg = b.Telegram
+	}
+	if b.SMPP != nil {
+		b.newTransceiver = b.SMPP
+	}
+	b.initState()
+
+	b.startSubmitQueue()
+	var err error
+	b.store, err = openStore(b.config.Storepath, b.config.Storekey, b.config.Encryptaddresses)
+	if err != nil {
+		return fmt.Errorf("can't open store: %w", err)
+	}
+	b.store.cdr = b.writeCDR
+	if err := b.initQuotas(); err != nil {
+		return err
+	}
+	b.initSpend()
+	if err := b.startInbound(ctx); err != nil {
+		return err
+	}
+	if err := b.startHA(ctx, b.handlePDU); err != nil {
+		return err
+	}
+	if b.config.Heartbeatchat != "" {
+		go supervise(ctx, "heartbeat", b.heartbeat)
+	}
+	if b.config.Reportchat != "" {
+		go supervise(ctx, "daily report", b.dailyReport)
+	}
+	if b.hasRoles() {
+		if err := b.startUpdates(ctx); err != nil {
+			return err
+		}
+	}
+	b.server.Certfile, b.server.Keyfile = b.config.Certfile, b.config.Keyfile
+	b.server.Guard = func(h http.Handler) http.Handler { return b.guardAPI(b.requireAuth(h)) }
+	return b.server.Serve(ctx, b.listeners())
+}
+
+// Decodes UCS2 texts, big-endian unless there is a byte order mark.
+var utf16bom = unicode.BOMOverride(unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewDecoder())
+
+// handlePDU takes a PDU from an SMSC: a deliver_sm is decoded and queued
+// to be forwarded to Telegram as an SMS or matched to its message as a
+// receipt.
+func (b *Bridge) handlePDU(p pdu.Body) {
+	defer recoverPanic("pdu handler")
+
+	b.capturePDU(p)
+	if in, ok := b.decodeDeliver(b.runCtx, p); ok {
+		b.enqueueInbound(b.runCtx, in)
+	}
+}
+
+// decodeDeliver decodes the text of a deliver_sm. It reports false for
+// other PDUs, duplicates, parts of a message still incomplete and texts
+// that can't be decoded.
+func (b *Bridge) decodeDeliver(ctx context.Context, p pdu.Body) (inbound, bool) {
+	if b.debugLevel.Load() < 2 && !b.config.Masknumbers {
+		log.Printf("Message: %q", p)
+	}
+	if p.Header().ID != pdu.DeliverSMID {
+		return inbound{}, false
+	}
+	f := p.Fields()
+	tlv := p.TLVFields()
+	coding := f[pdufield.DataCoding]
+	src := f[pdufield.SourceAddr]
+	dst := f[pdufield.DestinationAddr]
+	txt := f[pdufield.ShortMessage]
+	longtext := tlv[pdutlv.TagMessagePayload]
+	var text string
+	var err error
+	if b.debugLevel.Load() < 2 {
+		log.Printf("ShortMessage: %q, TagMessagePayload: %q, Coding: %q", txt, longtext, coding)
+	}
+	raw := smppclient.UserData(f)
+	if len(raw) == 0 {
+		raw = longtext.Bytes()
+	}
+	if b.isDuplicate(ctx, src.String(), dst.String(), coding.String(), raw) {
+		log.Printf("Dropped duplicate deliver_sm from %s to %s", b.mask(src.String()), b.mask(dst.String()))
+		return inbound{}, false
+	}
+	esm := f[pdufield.ESMClass]
+	if esm != nil && smppclient.HasUDH(esm.Bytes()) {
+		var whole bool
+		if raw, whole = b.reassemble(ctx, src.String(), dst.String(), raw); !whole {
+			return inbound{}, false
+		}
+	}
+	if coding.String() == "8" {
+		text, _, err = transform.String(utf16bom, string(raw))
+		if err != nil {
+			log.Printf("Can't decode UTF16 message %q", raw)
+			errsTotal.Add(1)
+			// Keep undecodable text out of the main chat.
+			b.alert("decode", fmt.Sprintf("Dropped SMS from %s to %s, can't decode UTF16: %s. Raw: %x", b.mask(src.String()), b.mask(dst.String()), err, raw))
+			return inbound{}, false
+		}
+	} else {
+		text = string(raw)
+	}
+	if b.debugLevel.Load() < 2 {
+		log.Printf("Text: %q", text)
+	}
+	receipt := esm != nil && smppclient.IsReceipt(esm.Bytes())
+	return inbound{src: src.String(), dst: dst.String(), text: text, receipt: receipt}, true
+}
+
+func (b *Bridge) registerSubmit() {
+	b.handle(api.GroupAPI, "/", func(w http.ResponseWriter, r *http.Request) {
+		// A client that goes away takes its submit with it.
+		ctx, cancel := context.WithTimeout(r.Context(), b.config.Submittimeout.Duration)
+		defer cancel()
+		m := &Message{Src: r.FormValue("src"), Dst: r.FormValue("dst"), Text: r.FormValue("text")}
+		if id := identityOf(r); id != nil {
+			if !id.allowsSource(m.Src) {
+				http.Error(w, "Source address not allowed for "+id.Tenant, http.StatusForbidden)
+				return
+			}
+			m.Tenant = id.Tenant
+		}
+		// A client that picks the UUID can cancel the submit while it
+		// waits, before the answer tells it the UUID. The reservation
+		// keeps a second submit off it until the first is stored.
+		if u := strings.ToLower(r.FormValue("uuid")); u != "" {
+			if !uuidRe.MatchString(u) {
+				http.Error(w, "Bad uuid", http.StatusBadRequest)
+				return
+			}
+			if !b.waiting.reserve(u, m.Tenant) {
+				http.Error(w, "Message "+u+" exists", http.StatusConflict)
+				return
+			}
+			defer b.waiting.release(u)
+			if _, ok := b.store.ByUUID(u); ok {
+				http.Error(w, "Message "+u+" exists", http.StatusConflict)
+				return
+			}
+			m.UUID = u
+		}
+		err := b.sendSMS(ctx, m)
+		if m.ID != 0 {
+			w.Header().Set("X-Message-Id", m.UUID)
+		}
+		if busy, ok := isBusy(err); ok {
+			b.writeBusy(w, busy)
+			return
+		}
+		var quota *quotaError
+		if errors.As(err, &quota) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		if err == errNotLeader {
+			http.Error(w, "Not the leader.", http.StatusServiceUnavailable)
+			return
+		}
+		if err == errCancelled {
+			http.Error(w, "Cancelled.", http.StatusConflict)
+			return
+		}
+		if err == smpp.ErrNotConnected {
+			http.Error(w, "Oops.", http.StatusServiceUnavailable)
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			http.Error(w, "Timed out.", http.StatusGatewayTimeout)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("X-Estimated-Cost", strconv.FormatFloat(m.Cost, 'f', -1, 64))
+		if b.config.Currency != "" {
+			w.Header().Set("X-Cost-Currency", b.config.Currency)
+		}
+		io.WriteString(w, m.SMSCID)
+	})
+}
@@ -0,0 +1,210 @@
+package bridge
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"telegram-smpp-bot/api"
+	"telegram-smpp-bot/bridgetest"
+)
+
+// Addresses of the fake SMSCs the tests bind to.
+const (
+	smscA = "127.0.0.1:2775"
+	smscB = "127.0.0.2:2775"
+)
+
+// testAdmin is the Telegram user the test configs make an admin.
+const testAdmin = 42
+
+// testBridge is a bridge running against the fakes of bridgetest, serving
+// its API on a unix socket.
+type testBridge struct {
+	*Bridge
+	smsc   *bridgetest.SMSC
+	tg     *bridgetest.Telegram
+	http   *http.Client
+	apikey string // Sent by do if set.
+}
+
+// startBridge runs a bridge for cfg until the test ends and returns it
+// once it is up. Unset, the chat is -100, the SMSC smscA and testAdmin an
+// admin, which starts the Telegram updates.
+func startBridge(t *testing.T, cfg *Config) *testBridge {
+	t.Helper()
+	if cfg.Chatid == "" {
+		cfg.Chatid = "-100"
+	}
+	if cfg.Smpp == "" && len(cfg.Smscs) == 0 {
+		cfg.Smpp = smscA
+	}
+	if len(cfg.Admins) == 0 {
+		cfg.Admins = []int64{testAdmin}
+	}
+	if cfg.Debug == 0 {
+		cfg.Debug = 3
+	}
+	sock := filepath.Join(t.TempDir(), "api.sock")
+	cfg.Listeners = []api.Listener{{Address: sock, Network: "unix", Serve: []string{api.GroupAPI, api.GroupHealth}}}
+
+	tb := &testBridge{
+		Bridge: New(cfg),
+		smsc:   bridgetest.NewSMSC(),
+		tg:     bridgetest.NewTelegram(),
+		http: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sock)
+			},
+		}},
+	}
+	tb.SMPP, tb.Telegram = tb.smsc.New, tb.tg
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- tb.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run: %s", err)
+		}
+	})
+	// Run deletes the webhook once everything else is set up.
+	waitFor(t, "the bridge to start", func() bool {
+		select {
+		case err := <-done:
+			t.Fatalf("Run: %v", err)
+		default:
+		}
+		_, err := os.Stat(sock)
+		return err == nil && len(tb.tg.Calls("deleteWebhook")) > 0
+	})
+	return tb
+}
+
+// do makes an API request with form as the query of a GET or the body of
+// a POST and returns the response with its body read.
+func (tb *testBridge) do(t *testing.T, method, path string, form url.Values) (*http.Response, string) {
+	t.Helper()
+	u := "http://bridge" + path
+	var body io.Reader
+	if method == http.MethodGet {
+		u += "?" + form.Encode()
+	} else {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if tb.apikey != "" {
+		req.Header.Set("X-API-Key", tb.apikey)
+	}
+	resp, err := tb.http.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %s", method, path, err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+// submit sends an SMS through the API and returns the stored message.
+func (tb *testBridge) submit(t *testing.T, dst, text string) *Message {
+	t.Helper()
+	resp, body := tb.do(t, http.MethodPost, "/", url.Values{"src": {"TEST"}, "dst": {dst}, "text": {text}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("submit to %s: %s %s", dst, resp.Status, body)
+	}
+	m, ok := tb.store.ByUUID(resp.Header.Get("X-Message-Id"))
+	if !ok {
+		t.Fatalf("submit to %s: no message %q stored", dst, resp.Header.Get("X-Message-Id"))
+	}
+	return m
+}
+
+// sent returns the texts posted to Telegram so far.
+func (tb *testBridge) sent() []string {
+	var texts []string
+	for _, c := range tb.tg.Calls("sendMessage") {
+		texts = append(texts, c.Form["text"])
+	}
+	return texts
+}
+
+// waitForSent waits until n messages were posted to Telegram and returns
+// their texts.
+func (tb *testBridge) waitForSent(t *testing.T, n int) []string {
+	t.Helper()
+	waitFor(t, "messages posted to Telegram", func() bool { return len(tb.sent()) >= n })
+	return tb.sent()
+}
+
+// waitFor polls cond until it holds, failing the test after a while.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	tests := []struct {
+		name   string
+		form   url.Values
+		status int
+		stored string // Status of the stored message, none if empty.
+	}{
+		{"ok", url.Values{"src": {"TEST"}, "dst": {"+4915112345678"}, "text": {"hi"}}, http.StatusOK, statusSubmitted},
+		{"own uuid", url.Values{"src": {"TEST"}, "dst": {"+4915112345678"}, "text": {"hi"}, "uuid": {"0b9a1e4c-2f7d-4c1b-9a55-6f0e3d2c1b0a"}}, http.StatusOK, statusSubmitted},
+		{"bad uuid", url.Values{"src": {"TEST"}, "dst": {"+4915112345678"}, "text": {"hi"}, "uuid": {"42"}}, http.StatusBadRequest, ""},
+		{"source not allowed", url.Values{"src": {"OTHER"}, "dst": {"+4915112345678"}, "text": {"hi"}}, http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := startBridge(t, &Config{Tenants: map[string]Tenant{"t": {Apikeys: []string{"key"}, Sources: []string{"TEST"}}}})
+			tb.apikey = "key"
+			resp, body := tb.do(t, http.MethodPost, "/", tt.form)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("Got %s %s, want %d", resp.Status, body, tt.status)
+			}
+			ref := resp.Header.Get("X-Message-Id")
+			if tt.stored == "" {
+				if ref != "" {
+					t.Errorf("Got X-Message-Id %q, want none", ref)
+				}
+				return
+			}
+			m, ok := tb.store.ByUUID(ref)
+			if !ok {
+				t.Fatalf("No message %q stored", ref)
+			}
+			if u := tt.form.Get("uuid"); u != "" && m.UUID != u {
+				t.Errorf("Got UUID %s, want %s", m.UUID, u)
+			}
+			if m.Status != tt.stored || m.Tenant != "t" {
+				t.Errorf("Got status %q of tenant %q, want %q of t", m.Status, m.Tenant, tt.stored)
+			}
+			subs := tb.smsc.Submitted()
+			if len(subs) != 1 || body != subs[0].IDs[0] || m.SMSCID != subs[0].IDs[0] {
+				t.Errorf("Got body %q and SMSC id %q for submits %+v", body, m.SMSCID, subs)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Attempts at delivering a callback before it is given up.
+const callbackAttempts = 4
+
+// callbackEvent is the body of a callback.
+type callbackEvent struct {
+	Event   string   `json:"event"` // "sms" or "dlr".
+	Time    int64    `json:"time"`
+	Message *Message `json:"message,omitempty"`
+	Receipt string   `json:"receipt,omitempty"` // Text of a delivery receipt.
+}
+
+// postCallback delivers an event to config.Callbackurl in the background.
+// Failed posts are retried with growing pauses.
+func (b *Bridge) postCallback(ev callbackEvent) {
+	if b.config.Callbackurl == "" {
+		return
+	}
+	ev.Time = time.Now().Unix()
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("Can't encode %s callback. Error: %s", ev.Event, err)
+		return
+	}
+	go func() {
+		defer recoverPanic("callback sender")
+		delay := time.Second
+		for i := 1; ; i++ {
+			err := b.sendCallback(b.runCtx, body)
+			if err == nil {
+				return
+			}
+			if i == callbackAttempts {
+				log.Printf("Giving up %s callback to %s. Error: %s", ev.Event, b.config.Callbackurl, err)
+				errsTotal.Add(1)
+				b.alert("callback", "Can't deliver callback to "+b.config.Callbackurl+": "+err.Error())
+				return
+			}
+			if !sleep(b.runCtx, delay) {
+				return
+			}
+			delay *= 4
+		}
+	}()
+}
+
+// sendCallback posts body once. Receivers verify the X-Signature header,
+// "sha256=" and the hex HMAC-SHA256 of X-Timestamp, a dot and the body,
+// keyed with the shared secret, and reject old timestamps to stop replays.
+func (b *Bridge) sendCallback(ctx context.Context, body []byte) error {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.config.Callbackurl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Timestamp", ts)
+	if b.config.Callbacksecret != "" {
+		req.Header.Set("X-Signature", "sha256="+sign(b.config.Callbacksecret, ts, body))
+	}
+	resp, err := b.callbackClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// sign returns the hex HMAC-SHA256 of "ts.body".
+func sign(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
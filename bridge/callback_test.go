@@ -0,0 +1,96 @@
+package bridge
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// callbackReceiver records the callbacks posted to it, failing the first
+// fail of them.
+type callbackReceiver struct {
+	mu    sync.Mutex
+	fail  int
+	posts []callbackPost
+}
+
+type callbackPost struct {
+	header http.Header
+	body   []byte
+}
+
+func (c *callbackReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.posts = append(c.posts, callbackPost{r.Header, body})
+	if len(c.posts) <= c.fail {
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+	}
+}
+
+func (c *callbackReceiver) received() []callbackPost {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]callbackPost(nil), c.posts...)
+}
+
+func TestCallbacks(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		fail   int
+		event  string
+	}{
+		{"sms", "s3cret", 0, eventSMS},
+		{"receipt", "s3cret", 0, eventDLR},
+		{"unsigned", "", 0, eventSMS},
+		{"retried", "s3cret", 1, eventSMS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rcv := &callbackReceiver{fail: tt.fail}
+			srv := httptest.NewServer(rcv)
+			defer srv.Close()
+			tb := startBridge(t, &Config{Callbackurl: srv.URL, Callbacksecret: tt.secret})
+			if tt.event == eventDLR {
+				m := tb.submit(t, "+4915112345678", "hi")
+				tb.smsc.Receipt("+4915112345678", "TEST", m.SMSCID, "DELIVRD")
+			} else {
+				tb.smsc.Deliver("+4915112345678", "TEST", "hello")
+			}
+			waitFor(t, "the callback", func() bool { return len(rcv.received()) > tt.fail })
+
+			posts := rcv.received()
+			if len(posts) != tt.fail+1 {
+				t.Fatalf("Got %d posts, want %d", len(posts), tt.fail+1)
+			}
+			p := posts[tt.fail]
+			var ev callbackEvent
+			if err := json.Unmarshal(p.body, &ev); err != nil {
+				t.Fatal(err)
+			}
+			if ev.Event != tt.event || ev.Message == nil {
+				t.Errorf("Got event %s", p.body)
+			}
+			sig := p.header.Get("X-Signature")
+			if tt.secret == "" {
+				if sig != "" {
+					t.Errorf("Got X-Signature %q without a secret", sig)
+				}
+				return
+			}
+			mac := hmac.New(sha256.New, []byte(tt.secret))
+			mac.Write([]byte(p.header.Get("X-Timestamp") + "." + string(p.body)))
+			if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); sig != want {
+				t.Errorf("Got X-Signature %q, want %q", sig, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"telegram-smpp-bot/api"
+)
+
+// errCancelled is returned by sendSMS for a message cancelled while it
+// waited for the rate limiters.
+var errCancelled = errors.New("cancelled")
+
+// waitingSubmits are the outbound messages on their way to the store, by
+// UUID. The API reserves the UUIDs clients pick, so that two submits can't
+// take the same one; messages waiting in submit for the rate limiters can
+// be cancelled until they go to the SMSC.
+type waitingSubmits struct {
+	sync.Mutex
+	m map[string]*waitingSubmit
+}
+
+type waitingSubmit struct {
+	tenant    string
+	reserved  bool               // Kept until release, not just while waiting.
+	cancel    context.CancelFunc // Set while waiting for the limiters.
+	cancelled bool
+}
+
+// reserve takes uuid for a submit of tenant until release and reports
+// whether it was free.
+func (w *waitingSubmits) reserve(uuid, tenant string) bool {
+	w.Lock()
+	defer w.Unlock()
+	if _, ok := w.m[uuid]; ok {
+		return false
+	}
+	if w.m == nil {
+		w.m = make(map[string]*waitingSubmit)
+	}
+	w.m[uuid] = &waitingSubmit{tenant: tenant, reserved: true}
+	return true
+}
+
+// release frees a reserved uuid once its message is stored.
+func (w *waitingSubmits) release(uuid string) {
+	w.Lock()
+	defer w.Unlock()
+	delete(w.m, uuid)
+}
+
+// wait makes uuid cancellable with cancel until done.
+func (w *waitingSubmits) wait(uuid, tenant string, cancel context.CancelFunc) {
+	w.Lock()
+	defer w.Unlock()
+	if w.m == nil {
+		w.m = make(map[string]*waitingSubmit)
+	}
+	s, ok := w.m[uuid]
+	if !ok {
+		s = &waitingSubmit{tenant: tenant}
+		w.m[uuid] = s
+	}
+	s.cancel = cancel
+}
+
+// done ends the wait of uuid and reports whether it was not cancelled.
+func (w *waitingSubmits) done(uuid string) bool {
+	w.Lock()
+	defer w.Unlock()
+	s, ok := w.m[uuid]
+	if !ok {
+		return false
+	}
+	s.cancel = nil
+	if !s.reserved {
+		delete(w.m, uuid)
+	}
+	return !s.cancelled
+}
+
+// cancelWait stops the wait of uuid if allowed returns true for its
+// tenant and reports whether it was waiting.
+func (w *waitingSubmits) cancelWait(uuid string, allowed func(tenant string) bool) bool {
+	w.Lock()
+	defer w.Unlock()
+	s, ok := w.m[uuid]
+	if !ok || s.cancel == nil || !allowed(s.tenant) {
+		return false
+	}
+	s.cancel()
+	s.cancel, s.cancelled = nil, true
+	return true
+}
+
+func (b *Bridge) registerCancel() {
+	// POST /api/v2/messages/cancel with id=<uuid> cancels an outbound SMS
+	// still waiting for the rate limiters, submitted with that uuid; it is
+	// stored as cancelled.
+	// Messages already at the SMSC answer 409, go-smpp has no cancel_sm.
+	// A tenant can only cancel its own.
+	b.handle(api.GroupAPI, "/api/v2/messages/cancel", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ref := strings.ToLower(r.FormValue("id"))
+		id := identityOf(r)
+		allowed := func(tenant string) bool {
+			return id == nil || id.Tenant == tenant || b.isAdmin(r)
+		}
+		if b.waiting.cancelWait(ref, allowed) {
+			b.audit(r, "cancel", ref, 1, nil)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if m, ok := b.store.Lookup(ref); ok && allowed(m.Tenant) {
+			http.Error(w, "Message "+m.UUID+" is "+m.Status+", too late to cancel", http.StatusConflict)
+			return
+		}
+		http.NotFound(w, r)
+	})
+}
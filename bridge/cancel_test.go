@@ -0,0 +1,120 @@
+package bridge
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// isWaiting reports whether the submit of uuid waits for the limiters.
+func (tb *testBridge) isWaiting(uuid string) bool {
+	tb.waiting.Lock()
+	defer tb.waiting.Unlock()
+	s, ok := tb.waiting.m[uuid]
+	return ok && s.cancel != nil
+}
+
+func TestCancel(t *testing.T) {
+	const id = "0b9a1e4c-2f7d-4c1b-9a55-6f0e3d2c1b0a"
+	tests := []struct {
+		name    string
+		apikey  string // Of the cancel.
+		ref     string
+		waiting bool // Cancel while the submit waits, or after it.
+		status  int  // Of the cancel.
+		submit  int  // Status of the submit.
+		stored  string
+	}{
+		{"waiting", "t", id, true, http.StatusNoContent, http.StatusConflict, statusCancelled},
+		{"by an admin", "admin", id, true, http.StatusNoContent, http.StatusConflict, statusCancelled},
+		{"by another tenant", "u", id, true, http.StatusNotFound, http.StatusOK, statusSubmitted},
+		{"too late", "t", id, false, http.StatusConflict, http.StatusOK, statusSubmitted},
+		{"unknown", "t", "5d1f7a2e-8c3b-4e6a-b0d9-1a2b3c4d5e6f", true, http.StatusNotFound, http.StatusOK, statusSubmitted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A submit a second, the one after the first waits.
+			tb := startBridge(t, &Config{
+				Ratelimits: []Ratelimit{{Rate: 1, Burst: 1}},
+				Tenants: map[string]Tenant{
+					"t":     {Apikeys: []string{"t"}},
+					"u":     {Apikeys: []string{"u"}},
+					"admin": {Admin: true, Apikeys: []string{"admin"}},
+				},
+			})
+			tb.apikey = "t"
+			tb.submit(t, "+4915112345678", "first")
+
+			form := url.Values{"src": {"TEST"}, "dst": {"+4915112345678"}, "text": {"second"}, "uuid": {id}}
+			req, _ := http.NewRequest(http.MethodPost, "http://bridge/", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("X-API-Key", "t")
+			done := make(chan int, 1)
+			go func() {
+				resp, err := tb.http.Do(req)
+				if err != nil {
+					done <- 0
+					return
+				}
+				resp.Body.Close()
+				done <- resp.StatusCode
+			}()
+			var submit int
+			if tt.waiting {
+				waitFor(t, "the submit to wait", func() bool { return tb.isWaiting(id) })
+			} else {
+				submit = <-done
+			}
+
+			canceller := *tb
+			canceller.apikey = tt.apikey
+			if resp, body := canceller.do(t, http.MethodPost, "/api/v2/messages/cancel", url.Values{"id": {tt.ref}}); resp.StatusCode != tt.status {
+				t.Errorf("Got %s %s, want %d", resp.Status, body, tt.status)
+			}
+			if tt.waiting {
+				submit = <-done
+			}
+			if submit != tt.submit {
+				t.Errorf("Got submit status %d, want %d", submit, tt.submit)
+			}
+			if m, ok := tb.store.ByUUID(id); !ok || m.Status != tt.stored {
+				t.Errorf("Got stored %+v, want status %s", m, tt.stored)
+			}
+		})
+	}
+}
+
+func TestDuplicateUUID(t *testing.T) {
+	const id = "0b9a1e4c-2f7d-4c1b-9a55-6f0e3d2c1b0a"
+	tb := startBridge(t, &Config{Ratelimits: []Ratelimit{{Rate: 1, Burst: 1}}})
+	tb.submit(t, "+4915112345678", "first")
+
+	form := url.Values{"src": {"TEST"}, "dst": {"+4915112345678"}, "text": {"second"}, "uuid": {id}}
+	done := make(chan int, 1)
+	go func() {
+		resp, err := tb.http.PostForm("http://bridge/", form)
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	waitFor(t, "the submit to wait", func() bool { return tb.isWaiting(id) })
+
+	// Taken while the first waits, in any case, and once it is stored.
+	form.Set("uuid", strings.ToUpper(id))
+	if resp, body := tb.do(t, http.MethodPost, "/", form); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Got %s %s while waiting, want 409", resp.Status, body)
+	}
+	if status := <-done; status != http.StatusOK {
+		t.Fatalf("Got first submit status %d, want 200", status)
+	}
+	if resp, body := tb.do(t, http.MethodPost, "/", form); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Got %s %s once stored, want 409", resp.Status, body)
+	}
+	if m, ok := tb.store.ByUUID(id); !ok || m.Text != "second" {
+		t.Errorf("Got stored %+v, want the second message", m)
+	}
+}
@@ -0,0 +1,238 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/fiorix/go-smpp/smpp/pdu"
+
+	"telegram-smpp-bot/smppclient"
+	"telegram-smpp-bot/telegramsink"
+)
+
+// captureFile receives the PDUs taken from the SMSCs, so that a user can
+// send them in and Replay can feed them through the pipeline again. Each
+// record is a 4 byte big-endian length, a kind byte and the PDU as it came
+// on the wire, sealed with the store key if there is one.
+type captureFile struct {
+	sync.Mutex
+	f    *os.File
+	aead cipher.AEAD // Nil to write the PDUs as they are.
+	size int64       // Bytes written so far.
+	max  int64       // Capturing stops past this size.
+}
+
+// Kinds of capture records.
+const (
+	capturePlain  = 0
+	captureSealed = 1
+)
+
+// captureAD binds sealed capture records to their use, so they can't pass
+// for store fields.
+var captureAD = []byte("capture")
+
+// initCapture opens config.Capturefile. Without a store key it would keep
+// texts and numbers in the clear, so it is refused when numbers are to be
+// masked.
+func (b *Bridge) initCapture() error {
+	if b.config.Capturefile == "" {
+		return nil
+	}
+	var aead cipher.AEAD
+	if b.config.Storekey != "" {
+		var err error
+		if aead, err = storeCipher(b.config.Storekey); err != nil {
+			return err
+		}
+	} else if b.config.Masknumbers {
+		return fmt.Errorf("capturefile needs a storekey to seal it when masknumbers is set")
+	}
+	f, err := os.OpenFile(b.config.Capturefile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("can't open capture file: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("can't open capture file: %w", err)
+	}
+	log.Printf("Capturing PDUs to %s, sealed: %t", b.config.Capturefile, aead != nil)
+	b.capture.f, b.capture.aead = f, aead
+	b.capture.size, b.capture.max = fi.Size(), int64(b.config.Capturesize)<<20
+	return nil
+}
+
+// capturePDU appends p to the capture file if there is one and it is not
+// full yet.
+func (b *Bridge) capturePDU(p pdu.Body) {
+	b.capture.Lock()
+	defer b.capture.Unlock()
+	c := &b.capture
+	if c.f == nil {
+		return
+	}
+	data, err := smppclient.Serialize(p)
+	if err != nil {
+		log.Printf("Can't capture PDU. Error: %s", err)
+		return
+	}
+	kind := byte(capturePlain)
+	if c.aead != nil {
+		nonce := make([]byte, c.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			log.Printf("Can't capture PDU. Error: %s", err)
+			return
+		}
+		kind, data = captureSealed, c.aead.Seal(nonce, nonce, data, captureAD)
+	}
+	rec := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(rec, uint32(len(data)))
+	rec[4] = kind
+	rec = append(rec, data...)
+	if c.size+int64(len(rec)) > c.max {
+		log.Printf("Capture file %s reached %d MB, capturing stopped", b.config.Capturefile, b.config.Capturesize)
+		b.alert("capture", fmt.Sprintf("Capture file reached %d MB, capturing stopped", b.config.Capturesize))
+		c.f.Close()
+		c.f = nil
+		return
+	}
+	if _, err := c.f.Write(rec); err != nil {
+		log.Printf("Can't write capture file. Error: %s", err)
+		return
+	}
+	c.size += int64(len(rec))
+}
+
+// readCapture returns the next PDU of a capture, opening sealed records
+// with aead. Records can't be longer than a PDU sealed, so that a damaged
+// length doesn't make it allocate gigabytes.
+func readCapture(r io.Reader, aead cipher.AEAD) (pdu.Body, error) {
+	var head [5]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	limit := pdu.MaxSize
+	if aead != nil {
+		limit += aead.NonceSize() + aead.Overhead()
+	}
+	n := binary.BigEndian.Uint32(head[:4])
+	if n > uint32(limit) {
+		return nil, fmt.Errorf("record of %d bytes, longer than a PDU", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	switch head[4] {
+	case capturePlain:
+	case captureSealed:
+		if aead == nil {
+			return nil, fmt.Errorf("sealed record and there is no store key")
+		}
+		n := aead.NonceSize()
+		if len(data) < n {
+			return nil, fmt.Errorf("bad sealed record")
+		}
+		var err error
+		if data, err = aead.Open(nil, data[:n], data[n:], captureAD); err != nil {
+			return nil, fmt.Errorf("can't open sealed record: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown record kind %d", head[4])
+	}
+	return pdu.Decode(bytes.NewReader(data))
+}
+
+// Replay reads a capture made with the capturefile option from r and
+// feeds its PDUs through the pipeline of Run, from decoding to keyword
+// moderation and formatting, in dry run: nothing is bound or kept, the
+// moderation, translation and lookup services are not called, callbacks
+// are not posted, and w gets what would have been posted to Telegram.
+// Sealed captures need the store key of cfg.
+func Replay(ctx context.Context, cfg *Config, r io.Reader, w io.Writer) error {
+	c := *cfg
+	c.Callbackurl, c.Capturefile, c.Storepath, c.Auditlog = "", "", "", ""
+	c.Cdr.Dir = ""
+	c.Moderation.Url, c.Translation.Url, c.Lookup.Url = "", "", ""
+	b := New(&c)
+	b.runCtx = ctx
+	b.setDefaults()
+	b.debugLevel.Store(int64(b.config.Debug))
+	for _, init := range []func() error{b.initNumbering, b.initModeration} {
+		if err := init(); err != nil {
+			return err
+		}
+	}
+	var aead cipher.AEAD
+	if c.Storekey != "" {
+		var err error
+		if aead, err = storeCipher(c.Storekey); err != nil {
+			return err
+		}
+	}
+	b.tg = &dryRun{w: w}
+	b.state = newMemoryState()
+	b.store, _ = openStore("", "", false)
+	for n := 1; ctx.Err() == nil; n++ {
+		p, err := readCapture(r, aead)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("PDU %d: %w", n, err)
+		}
+		in, ok := b.decodeDeliver(ctx, p)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(w, "PDU %d: ", n)
+		if in.receipt {
+			b.handleReceipt(ctx, in.src, in.dst, in.text)
+		} else {
+			b.forwardSMS(ctx, in.src, in.dst, in.text)
+		}
+	}
+	return ctx.Err()
+}
+
+// dryRun is a Telegram sender that prints what it is given.
+type dryRun struct {
+	mu sync.Mutex
+	w  io.Writer
+	id int64
+}
+
+func (d *dryRun) Call(ctx context.Context, method string, form map[string]string, result interface{}) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprintf(d.w, "%s %v\n\n", method, form)
+	return nil
+}
+
+func (d *dryRun) Upload(ctx context.Context, method string, form, files map[string]string, result interface{}) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprintf(d.w, "%s %v %v\n\n", method, form, files)
+	return nil
+}
+
+func (d *dryRun) Send(ctx context.Context, chat, topic, text string, markup interface{}) (*telegramsink.Message, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.id++
+	if topic != "" {
+		chat += "/" + topic
+	}
+	fmt.Fprintf(d.w, "to %s:\n%s\n\n", chat, text)
+	return &telegramsink.Message{MessageID: d.id}, nil
+}
@@ -0,0 +1,100 @@
+package bridge
+
+import (
+	"context"
+	"crypto/cipher"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaptureReplay(t *testing.T) {
+	const text = "captured secret"
+	tests := []struct {
+		name   string
+		key    string
+		reopen string // Store key of the replay.
+		sealed bool
+		err    string
+	}{
+		{"plain", "", "", false, ""},
+		{"sealed", testKey, testKey, true, ""},
+		{"sealed without the key", testKey, "", true, "there is no store key"},
+		{"sealed with another key", testKey, otherKey, true, "can't open sealed record"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "capture")
+			tb := startBridge(t, &Config{Capturefile: path, Storekey: tt.key})
+			tb.smsc.Deliver("+4915112345678", "TEST", text)
+			tb.smsc.DeliverPart("+4915112345678", "TEST", 7, 2, 1, "multi")
+			tb.smsc.DeliverPart("+4915112345678", "TEST", 7, 2, 2, "part")
+			waitFor(t, "the SMS", func() bool {
+				return len(tb.store.Between(time.Time{}, time.Now().Add(time.Hour))) == 2
+			})
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(b), text) == tt.sealed {
+				t.Errorf("Got capture %q, want it sealed: %v", b, tt.sealed)
+			}
+
+			// A replay stays offline.
+			services := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				t.Errorf("Replay called %s", r.URL)
+				http.Error(w, "offline", http.StatusServiceUnavailable)
+			}))
+			defer services.Close()
+			cfg := &Config{Chatid: "-100", Storekey: tt.reopen}
+			cfg.Moderation.Url, cfg.Translation.Url, cfg.Translation.Languages = services.URL, services.URL, []string{"de"}
+			var out strings.Builder
+			err = Replay(context.Background(), cfg, strings.NewReader(string(b)), &out)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "PDU 1: to -100:\nSMS from +4915112345678 to TEST :\n" + text + "\n\n" +
+				"PDU 3: to -100:\nSMS from +4915112345678 to TEST :\nmultipart\n\n"
+			if out.String() != want {
+				t.Errorf("Got replay %q, want %q", out.String(), want)
+			}
+		})
+	}
+}
+
+func TestReadCaptureLength(t *testing.T) {
+	aead, err := storeCipher(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		rec  string
+		aead cipher.AEAD
+		err  string
+	}{
+		{"huge", "\xff\xff\xff\xff\x00", nil, "longer than a PDU"},
+		{"longer than a PDU", "\x00\x00\x10\x01\x00", nil, "longer than a PDU"},
+		{"longer than a sealed PDU", "\x00\x00\x10\x1d\x01", aead, "longer than a PDU"},
+		{"a sealed PDU, cut short", "\x00\x00\x10\x1c\x01", aead, io.ErrUnexpectedEOF.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readCapture(strings.NewReader(tt.rec), tt.aead); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
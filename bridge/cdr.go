@@ -0,0 +1,184 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// CDR writes a call detail record for billing whenever a message is
+// stored, and again when its delivery receipt updates it. The "record"
+// column tells the two apart: bill the "new" records, which carry the
+// cost, and take the final status from the last "update" of the message.
+type CDR struct {
+	Dir    string   // Directory of the CDR files, off if empty.
+	Format string   // "csv" (the default) or "jsonl".
+	Fields []string // Columns in order, see cdrFields.
+	Rotate string   // Start a new file "daily" (the default) or "hourly".
+}
+
+// cdrFields are the columns a CDR or an export can have.
+var cdrFields = map[string]func(m *Message) interface{}{
+	"timestamp": func(m *Message) interface{} { return m.Time.UTC().Format(time.RFC3339) },
+	"written":   func(m *Message) interface{} { return time.Now().UTC().Format(time.RFC3339) },
+	"id":        func(m *Message) interface{} { return m.ID },
+	"uuid":      func(m *Message) interface{} { return m.UUID },
+	"direction": func(m *Message) interface{} { return m.Direction },
+	"src":       func(m *Message) interface{} { return m.Src },
+	"dst":       func(m *Message) interface{} { return m.Dst },
+	"text":      func(m *Message) interface{} { return m.Text },
+	"parts":     func(m *Message) interface{} { return m.Parts },
+	"encoding":  func(m *Message) interface{} { return m.Encoding },
+	"route":     func(m *Message) interface{} { return m.Route },
+	"smsc":      func(m *Message) interface{} { return m.Smsc },
+	"status":    func(m *Message) interface{} { return m.Status },
+	"error":     func(m *Message) interface{} { return m.Error },
+	"price":     func(m *Message) interface{} { return m.Price },
+	"cost":      func(m *Message) interface{} { return m.Cost },
+	"tenant":    func(m *Message) interface{} { return m.Tenant },
+	"mcc":       func(m *Message) interface{} { return m.Mcc },
+	"mnc":       func(m *Message) interface{} { return m.Mnc },
+	"country":   func(m *Message) interface{} { return m.Country },
+	"smsc_id":   func(m *Message) interface{} { return m.SMSCID },
+}
+
+// cdrRecord is the CDR column saying whether a record is of a new
+// message or of an update. Exports don't have it.
+const cdrRecord = "record"
+
+var defaultCDRFields = []string{"id", "timestamp", "direction", "src", "dst", "parts", "encoding", "route", "status", "price", cdrRecord}
+
+func (b *Bridge) initCDR() error {
+	c := &b.config.Cdr
+	if c.Dir == "" {
+		return nil
+	}
+	if len(c.Fields) == 0 {
+		c.Fields = defaultCDRFields
+	}
+	for _, f := range c.Fields {
+		if cdrFields[f] == nil && f != cdrRecord {
+			return fmt.Errorf("unknown CDR field %q", f)
+		}
+	}
+	switch c.Format {
+	case "":
+		c.Format = "csv"
+	case "csv", "jsonl":
+	default:
+		return fmt.Errorf("unknown CDR format %q", c.Format)
+	}
+	switch c.Rotate {
+	case "":
+		c.Rotate = "daily"
+	case "daily", "hourly":
+	default:
+		return fmt.Errorf("unknown CDR rotation %q", c.Rotate)
+	}
+	if err := os.MkdirAll(c.Dir, 0o750); err != nil {
+		return fmt.Errorf("can't create CDR directory: %w", err)
+	}
+	return nil
+}
+
+// writeCDR appends the record of m to the current CDR file, as an update
+// of a message already recorded if update is set. Messages that never
+// reached the SMSC are not billed and get none.
+func (b *Bridge) writeCDR(m *Message, update bool) {
+	c := b.config.Cdr
+	if c.Dir == "" || neverSent(m) {
+		return
+	}
+	record := "new"
+	if update {
+		record = "update"
+	}
+	var buf bytes.Buffer
+	if c.Format == "jsonl" {
+		buf.WriteByte('{')
+		for i, f := range c.Fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			k, _ := json.Marshal(f)
+			var v []byte
+			if f == cdrRecord {
+				v, _ = json.Marshal(record)
+			} else {
+				v, _ = json.Marshal(cdrFields[f](m))
+			}
+			buf.Write(k)
+			buf.WriteByte(':')
+			buf.Write(v)
+		}
+		buf.WriteString("}\n")
+	} else {
+		rec := make([]string, len(c.Fields))
+		for i, f := range c.Fields {
+			if f == cdrRecord {
+				rec[i] = record
+			} else {
+				rec[i] = cdrValue(f, m)
+			}
+		}
+		w := csv.NewWriter(&buf)
+		w.Write(rec)
+		w.Flush()
+	}
+
+	b.cdrs.Lock()
+	defer b.cdrs.Unlock()
+	if err := b.rotateCDR(c); err != nil {
+		log.Printf("Can't open CDR file. Error: %s", err)
+		errsTotal.Add(1)
+		return
+	}
+	if _, err := b.cdrs.f.Write(buf.Bytes()); err != nil {
+		log.Printf("Can't write CDR of message %d. Error: %s", m.ID, err)
+		errsTotal.Add(1)
+	}
+}
+
+// cdrValue formats field f of m for CSV.
+func cdrValue(f string, m *Message) string {
+	switch v := cdrFields[f](m).(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// rotateCDR makes cdrs.f the file for the current day or hour, starting
+// new CSV files with a header. Must be called with cdrs held.
+func (b *Bridge) rotateCDR(c CDR) error {
+	layout := "20060102"
+	if c.Rotate == "hourly" {
+		layout = "2006010215"
+	}
+	name := filepath.Join(c.Dir, "cdr-"+time.Now().UTC().Format(layout)+"."+c.Format)
+	if b.cdrs.f != nil && b.cdrs.name == name {
+		return nil
+	}
+	if b.cdrs.f != nil {
+		b.cdrs.f.Close()
+		b.cdrs.f = nil
+	}
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	if fi, err := f.Stat(); err == nil && fi.Size() == 0 && c.Format == "csv" {
+		w := csv.NewWriter(f)
+		w.Write(c.Fields)
+		w.Flush()
+	}
+	b.cdrs.f, b.cdrs.name = f, name
+	return nil
+}
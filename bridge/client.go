@@ -0,0 +1,77 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"telegram-smpp-bot/telegramsink"
+)
+
+// initTelegramClient builds tg from config, see newTransport.
+func (b *Bridge) initTelegramClient() error {
+	transport, err := b.newTransport()
+	if err != nil {
+		return err
+	}
+	c := &telegramsink.Client{
+		HTTP:        &http.Client{Transport: transport},
+		URL:         b.config.Apiurl,
+		Token:       b.config.Botid + ":" + b.config.Botkey,
+		ReadTimeout: b.config.Readtimeout.Duration,
+	}
+	c.Debug.Store(b.config.Debug < 3)
+	b.tg = c
+	return nil
+}
+
+// initServiceClient builds the client of the lookup, moderation and
+// translation services, with the transport of the Bot API calls and
+// config.Servicetimeout as a deadline of last resort.
+func (b *Bridge) initServiceClient() error {
+	transport, err := b.newTransport()
+	if err != nil {
+		return err
+	}
+	b.serviceClient = &http.Client{Transport: transport, Timeout: b.config.Servicetimeout.Duration}
+	return nil
+}
+
+// newTransport returns a transport that resolves names with resolver and
+// goes through the SOCKS5 proxy if one is set, else through config.Proxy,
+// else through the proxy named by the environment.
+func (b *Bridge) newTransport() (*http.Transport, error) {
+	dialer := &net.Dialer{
+		Timeout:   b.config.Connecttimeout.Duration,
+		KeepAlive: b.config.Keepalive.Duration,
+	}
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment, // HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+		DialContext:         b.resolver.dialContext(dialer),
+		TLSHandshakeTimeout: b.config.Connecttimeout.Duration,
+		MaxIdleConns:        b.config.Maxidleconns,
+		MaxIdleConnsPerHost: b.config.Maxidleconns,
+		IdleConnTimeout:     90 * time.Second,
+		ForceAttemptHTTP2:   true,
+	}
+	if b.config.Proxy != "" {
+		u, err := url.Parse(b.config.Proxy)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("bad proxy URL %q", b.config.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	if p := b.config.Socks5; p.Host != "" {
+		// net/http speaks SOCKS5 itself and leaves name resolution to the
+		// proxy, which is what blocked networks need.
+		u := &url.URL{Scheme: "socks5", Host: net.JoinHostPort(p.Host, strconv.Itoa(p.Port))}
+		if p.Username != "" {
+			u.User = url.UserPassword(p.Username, p.Password)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	return transport, nil
+}
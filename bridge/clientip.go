@@ -0,0 +1,181 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// initClientIP parses the proxy and allowlist CIDRs from config.
+func (b *Bridge) initClientIP() error {
+	var err error
+	if b.trustedProxies, err = parsePrefixes("trustedproxies", b.config.Trustedproxies); err != nil {
+		return err
+	}
+	if b.allowedIPs, err = parsePrefixes("allowedips", b.config.Allowedips); err != nil {
+		return err
+	}
+	burst := b.config.Ipburst
+	if burst < 1 {
+		burst = 1
+	}
+	b.ipLimiters = &limiterMap{m: make(map[netip.Addr]*ipLimiter), limit: rate.Limit(b.config.Iprate), burst: burst}
+	if b.config.Iprate > 0 {
+		go supervise(b.runCtx, "ip limiter cleanup", b.ipLimiters.cleanup)
+	}
+	return nil
+}
+
+// parsePrefixes parses CIDRs, taking a bare address as a single host.
+func parsePrefixes(name string, cidrs []string) ([]netip.Prefix, error) {
+	var ps []netip.Prefix
+	for _, c := range cidrs {
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			a, aerr := netip.ParseAddr(c)
+			if aerr != nil {
+				return nil, fmt.Errorf("bad %s entry %q: %w", name, c, err)
+			}
+			p = netip.PrefixFrom(a, a.BitLen())
+		}
+		ps = append(ps, p.Masked())
+	}
+	return ps, nil
+}
+
+func contains(ps []netip.Prefix, a netip.Addr) bool {
+	a = a.Unmap()
+	for _, p := range ps {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the caller of r, ::1 for a unix socket.
+// Forwarding headers are only believed when the request comes from a
+// trusted proxy, or through a unix socket with config.Trustunixsocket:
+// X-Forwarded-For is read from the right, skipping trusted proxies, then
+// X-Real-IP is used.
+func (b *Bridge) clientIP(r *http.Request) netip.Addr {
+	peer, local := remoteAddr(r)
+	if local && !b.config.Trustunixsocket || !local && !contains(b.trustedProxies, peer) {
+		return peer
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			a, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			peer = a.Unmap()
+			if !contains(b.trustedProxies, peer) {
+				return peer
+			}
+		}
+		return peer
+	}
+	if a, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return a.Unmap()
+	}
+	return peer
+}
+
+// remoteAddr returns the address of the connection's peer, and whether it
+// came through a unix socket, which has none.
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return netip.IPv6Loopback(), true
+	}
+	a, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.IPv6Loopback(), true
+	}
+	return a.Unmap(), false
+}
+
+// limiterMap holds the per client IP rate limiters of the API.
+type limiterMap struct {
+	limit rate.Limit
+	burst int
+
+	mu sync.Mutex
+	m  map[netip.Addr]*ipLimiter
+}
+
+type ipLimiter struct {
+	*rate.Limiter
+	seen time.Time
+}
+
+func (lm *limiterMap) allow(a netip.Addr) bool {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	l, ok := lm.m[a]
+	if !ok {
+		l = &ipLimiter{Limiter: rate.NewLimiter(lm.limit, lm.burst)}
+		lm.m[a] = l
+	}
+	l.seen = time.Now()
+	return l.Allow()
+}
+
+// cleanup forgets clients that have been quiet for a while.
+func (lm *limiterMap) cleanup(ctx context.Context) {
+	for sleep(ctx, time.Minute) {
+		lm.mu.Lock()
+		for a, l := range lm.m {
+			if time.Since(l.seen) > 10*time.Minute {
+				delete(lm.m, a)
+			}
+		}
+		lm.mu.Unlock()
+	}
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// guardAPI wraps an API handler with the access log, the IP allowlist and
+// the per-IP rate limit, all keyed by the real client address.
+func (b *Bridge) guardAPI(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := b.clientIP(r)
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		if b.config.Accesslog {
+			defer func() {
+				log.Printf("API %s %s %s from %s: %d in %s", r.Method, r.URL.Path, r.Proto, ip, rec.status, time.Since(start).Round(time.Millisecond))
+			}()
+		}
+		if len(b.allowedIPs) > 0 && !contains(b.allowedIPs, ip) {
+			http.Error(rec, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if b.config.Iprate > 0 && !b.ipLimiters.allow(ip) {
+			rec.Header().Set("Retry-After", "1")
+			http.Error(rec, "Too many requests from "+ip.String(), http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,115 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+
+	"telegram-smpp-bot/telegramsink"
+)
+
+// command is a bot command and the least role allowed to run it.
+type command struct {
+	name string
+	need role
+	help string
+	run  func(ctx context.Context, msg *telegramsink.Message, args string)
+}
+
+// commandTable returns the commands of b, in the order /help lists them.
+func (b *Bridge) commandTable() []command {
+	return []command{
+		{"help", roleViewer, "list the commands you may use", b.cmdHelp},
+		{"status", roleViewer, "show the SMPP bind and traffic counters", b.cmdStatus},
+		{"send", roleSender, "send an SMS step by step", func(ctx context.Context, msg *telegramsink.Message, _ string) { b.startWizard(ctx, msg) }},
+		{"cancel", roleSender, "abort /send", func(ctx context.Context, msg *telegramsink.Message, _ string) { b.cancelWizard(ctx, msg) }},
+		{"reply", roleSender, "answer a forwarded SMS: reply to it with /reply text", b.cmdReply},
+		{"rebind", roleAdmin, "reconnect to the SMSC", b.cmdRebind},
+		{"replay", roleAdmin, "forward stored SMS again: /replay id... or /replay from to", b.cmdReplay},
+		{"debug", roleAdmin, "log messages in full or not: /debug on|off|level", b.cmdDebug},
+		{"pause", roleAdmin, "stop forwarding SMS to Telegram, they are still stored", b.cmdPause},
+		{"resume", roleAdmin, "forward SMS to Telegram again", b.cmdResume},
+		{"rate", roleAdmin, "set the submit rate per second: /rate 5 or /rate scope 5", b.cmdRate},
+	}
+}
+
+// runCommand checks the sender's role and runs a command.
+func (b *Bridge) runCommand(ctx context.Context, msg *telegramsink.Message, name, args string) {
+	for _, c := range b.commands {
+		if c.name != name {
+			continue
+		}
+		if b.roleOf(msg.From.ID) < c.need {
+			b.reply(ctx, msg, fmt.Sprintf("/%s needs the %s role.", c.name, c.need), nil)
+			return
+		}
+		c.run(ctx, msg, args)
+		return
+	}
+}
+
+func (b *Bridge) cmdHelp(ctx context.Context, msg *telegramsink.Message, _ string) {
+	r := b.roleOf(msg.From.ID)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Your role: %s\n", r)
+	for _, c := range b.commands {
+		if r >= c.need {
+			fmt.Fprintf(&sb, "/%s — %s\n", c.name, html.EscapeString(c.help))
+		}
+	}
+	b.reply(ctx, msg, sb.String(), nil)
+}
+
+func (b *Bridge) cmdStatus(ctx context.Context, msg *telegramsink.Message, _ string) {
+	c := snapshot()
+	var text string
+	for _, s := range b.smscs {
+		text += fmt.Sprintf("SMPP bind to %s: %s\n", html.EscapeString(s.Name), html.EscapeString(s.watcher.state()))
+	}
+	text += fmt.Sprintf("Queue: %d/%d\nInbound queue: %d/%d\nSince start: %d in / %d out / %d errors",
+		len(b.submitSlots), cap(b.submitSlots), b.inboundDepth(), b.inboundCapacity(), c.in, c.out, c.errs)
+	if b.config.Ha.Lock != "" {
+		role := "follower"
+		if b.isLeader() {
+			role = "leader"
+		}
+		text += "\nHA: " + html.EscapeString(b.config.Ha.Instance) + " is " + role
+	}
+	if b.forwardingPaused.Load() {
+		text += "\n⏸ Forwarding is paused"
+	}
+	b.reply(ctx, msg, text, nil)
+}
+
+// cmdReply sends text back to the sender of the forwarded SMS the command
+// replies to, from the number that SMS was sent to.
+func (b *Bridge) cmdReply(ctx context.Context, msg *telegramsink.Message, text string) {
+	if msg.ReplyTo == nil {
+		b.reply(ctx, msg, "Reply to a forwarded SMS with /reply text.", nil)
+		return
+	}
+	orig, ok := b.store.ByTelegram(msg.Chat.ID, msg.ReplyTo.MessageID)
+	if !ok || orig.Direction != dirIn {
+		b.reply(ctx, msg, "That is not a forwarded SMS I know of.", nil)
+		return
+	}
+	if text == "" {
+		b.reply(ctx, msg, "Usage: /reply text", nil)
+		return
+	}
+	log.Printf("User %d replies to message %d from %s", msg.From.ID, orig.ID, b.mask(orig.Src))
+	m := &Message{Src: orig.Dst, Dst: orig.Src, Text: text}
+	if err := b.sendSMS(ctx, m); err != nil {
+		b.reply(ctx, msg, "❌ Reply failed: "+html.EscapeString(err.Error()), nil)
+		return
+	}
+	b.reply(ctx, msg, fmt.Sprintf("✅ Sent to %s as #%d", html.EscapeString(b.mask(orig.Src)), m.ID), nil)
+}
+
+func (b *Bridge) cmdRebind(ctx context.Context, msg *telegramsink.Message, _ string) {
+	log.Printf("User %d requested SMPP rebind", msg.From.ID)
+	b.rebindAll()
+	b.reply(ctx, msg, "Rebinding to "+html.EscapeString(strings.Join(b.smscNames(), ", "))+".", nil)
+}
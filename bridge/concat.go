@@ -0,0 +1,51 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"telegram-smpp-bot/smppclient"
+)
+
+// isDuplicate reports whether the same deliver_sm was already received
+// within config.Dedupwindow, as happens when the SMSC resends after a lost
+// response.
+func (b *Bridge) isDuplicate(ctx context.Context, src, dst, coding string, sm []byte) bool {
+	if b.config.Dedupwindow.Duration == 0 {
+		return false
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00", src, dst, coding)
+	h.Write(sm)
+	first, err := b.state.firstSeen(ctx, hex.EncodeToString(h.Sum(nil)), b.config.Dedupwindow.Duration)
+	if err != nil {
+		log.Printf("Can't check deliver_sm from %s for duplicates. Error: %s", b.mask(src), err)
+		return false
+	}
+	return !first
+}
+
+// reassemble strips the user data header from sm and, for a part of a
+// multipart SMS, holds it until all parts are in. It returns the whole
+// text once, when the last part arrives, and false before.
+func (b *Bridge) reassemble(ctx context.Context, src, dst string, sm []byte) ([]byte, bool) {
+	body, ref, total, seq, ok := smppclient.SplitUDH(sm)
+	if !ok || total == 1 {
+		return body, true
+	}
+	key := fmt.Sprintf("%s:%s:%d:%d", src, dst, ref, total)
+	parts, err := b.state.addPart(ctx, key, seq, total, body, b.config.Concatwait.Duration)
+	if err != nil {
+		// Better a part on its own than nothing.
+		log.Printf("Can't reassemble part %d/%d from %s. Error: %s", seq, total, b.mask(src), err)
+		return body, true
+	}
+	if parts == nil {
+		return nil, false
+	}
+	return bytes.Join(parts, nil), true
+}
@@ -0,0 +1,54 @@
+package bridge
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConcat(t *testing.T) {
+	// part is one deliver_sm, of a multipart SMS unless total is 0.
+	type part struct {
+		ref, total, seq int
+		text            string
+	}
+	tests := []struct {
+		name  string
+		parts []part
+		want  []string // Texts stored, in order.
+	}{
+		{"in order", []part{{7, 3, 1, "Hello, "}, {7, 3, 2, "multipart "}, {7, 3, 3, "world"}}, []string{"Hello, multipart world"}},
+		{"out of order", []part{{7, 3, 3, "world"}, {7, 3, 1, "Hello, "}, {7, 3, 2, "multipart "}}, []string{"Hello, multipart world"}},
+		{"incomplete", []part{{7, 3, 1, "Hello, "}, {7, 3, 3, "world"}}, nil},
+		{"one part", []part{{7, 1, 1, "alone"}}, []string{"alone"}},
+		{"interleaved", []part{{1, 2, 1, "a1 "}, {2, 2, 1, "b1 "}, {2, 2, 2, "b2"}, {1, 2, 2, "a2"}}, []string{"b1 b2", "a1 a2"}},
+		{"plain", []part{{0, 0, 0, "just text"}}, []string{"just text"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := startBridge(t, &Config{Inboundordered: true})
+			const src = "+4915112345678"
+			for _, p := range tt.parts {
+				if p.total == 0 {
+					tb.smsc.Deliver(src, "TEST", p.text)
+				} else {
+					tb.smsc.DeliverPart(src, "TEST", p.ref, p.total, p.seq, p.text)
+				}
+			}
+			// Ordered deliveries from one source are forwarded in turn, so
+			// once this one is in, so is everything before it.
+			tb.smsc.Deliver(src, "TEST", "end")
+			var got []string
+			waitFor(t, "the SMS", func() bool {
+				got = nil
+				for _, m := range tb.store.Between(time.Time{}, time.Now().Add(time.Hour)) {
+					got = append(got, m.Text)
+				}
+				return len(got) > 0 && got[len(got)-1] == "end"
+			})
+			if got = got[:len(got)-1]; len(got) != len(tt.want) || len(got) > 0 && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"telegram-smpp-bot/api"
+)
+
+// Config is the bridge configuration, read from a JSON file with
+// LoadConfig. Missing options get their defaults when the bridge runs.
+type Config struct {
+	Name       string
+	Botid      string
+	Botkey     string
+	Apiurl     string // Bot API server, https://api.telegram.org if empty.
+	Chattype   string
+	Chatid     string
+	Chattopic  string
+	Address    string
+	Smpp       string
+	Username   string
+	Password   string
+	Debug      int
+	Queuesize  int      // Max submits waiting in the pipeline before the API answers 429.
+	Queuewait  Duration // Max time a submit may wait for the rate limiter.
+	Windowsize uint     // Max unacknowledged submit_sm PDUs on the SMPP bind, 0 is unlimited.
+	OpsChatid  string   `json:"ops_chat_id"` // Admin chat for operational notifications, only logged if empty.
+	Flapdelay  Duration // How long a bind must stay down before it is reported.
+
+	Failoverafter int // Failed bind attempts before moving to the next SMSC address.
+
+	Submittimeout Duration // Max time of an API submit from lookup to submit_sm_resp.
+
+	Inboundworkers  int    // Workers forwarding inbound SMS and receipts to Telegram.
+	Inboundqueue    int    // Max decoded deliveries waiting for a worker, per worker with Inboundordered.
+	Inboundoverflow string // When the queue is full: "block" (the default) waits up to Inboundwait, "drop" drops and alerts at once.
+	Inboundordered  bool   // Forward the messages of each sender in arrival order, senders still in parallel.
+
+	Inboundwait Duration // Max time "block" stalls the read loop of a bind, and with it every response on it, before dropping.
+
+	Capturefile string // File the PDUs from the SMSCs are appended to, for the replay command. Off if empty.
+	Capturesize int    // Max size of Capturefile in MB, capturing stops there.
+
+	Heartbeatchat     string   // Chat for the periodic liveness message, disabled if empty.
+	Heartbeattopic    string   // Topic in Heartbeatchat, optional.
+	Heartbeatinterval Duration // Time between liveness messages.
+	Heartbeattime     string   // Local time of day ("09:00") of the first liveness message, optional.
+	Reportchat        string   // Chat for the daily statistics report, disabled if empty.
+	Reporttopic       string   // Topic in Reportchat, optional.
+	Reporttime        string   // Local time of day of the report, covering the day before.
+
+	Alertwindow  Duration            // Default throttling window of repeated error notifications.
+	Alertwindows map[string]Duration // Windows by error class ("telegram", "smpp:bind", ...).
+
+	Events map[string]Destination // Chat or topic overrides by event class ("sms", "dlr", "ops").
+
+	Storepath string  // Message store file, messages are kept in memory only if empty.
+	Admins    []int64 // Telegram user IDs with the admin role.
+	Senders   []int64 // Telegram user IDs with the sender role.
+	Viewers   []int64 // Telegram user IDs with the viewer role.
+	Source    string  // Source address of SMS sent from Telegram.
+
+	Updates           string // How Telegram updates are received: "polling" or "webhook".
+	Webhookurl        string // Public https URL of this server for the "webhook" mode.
+	Webhooksecret     string // Token Telegram sends along with every webhook call.
+	Webhookselfsigned bool   // Upload Certfile to Telegram when setting the webhook.
+	Certfile          string // TLS certificate of the listener, plain HTTP if empty.
+	Keyfile           string // TLS key of the listener.
+
+	Connecttimeout Duration // Bot API connect and TLS handshake timeout.
+	Readtimeout    Duration // Bot API call timeout, not counting the long poll wait.
+	Keepalive      Duration // TCP keep-alive period of Bot API connections.
+	Maxidleconns   int      // Idle Bot API connections kept for reuse.
+	Proxy          string   // HTTP(S) proxy URL for Bot API and service calls, overrides HTTPS_PROXY.
+	Socks5         Socks5   // SOCKS5 proxy for Bot API and service calls, overrides Proxy.
+	Servicetimeout Duration // Deadline of calls to the lookup, moderation and translation services.
+
+	Dns Dns // Resolver for the Telegram and SMSC hostnames.
+
+	Listeners []api.Listener // HTTP listeners, a single one on Address serving the API if empty.
+
+	Trustedproxies  []string // CIDRs of reverse proxies whose X-Forwarded-For and X-Real-IP are believed.
+	Trustunixsocket bool     // Believe them from unix socket listeners too, when a proxy is all that can connect.
+	Allowedips      []string // CIDRs allowed to use the API, everyone if empty.
+	Iprate          float64  // API requests per second per client IP, unlimited if 0.
+	Ipburst         int      // Burst of the per-IP limit.
+	Accesslog       bool     // Log every API request.
+
+	Tenants map[string]Tenant // API identities by name, the API is open if empty.
+	Jwt     JWT               // Bearer token authentication, off if Jwksurl is empty.
+
+	Callbackurl    string // URL inbound SMS and delivery receipts are posted to as JSON, off if empty.
+	Callbacksecret string // HMAC key of the callback X-Signature header.
+
+	Ha HA // Leader election between instances sharing one SMPP account.
+
+	Dedupwindow Duration // Identical deliver_sm within this are dropped, off if 0.
+	Concatwait  Duration // How long parts of a multipart SMS wait for the rest.
+	State       Redis    // Redis shared by instances for dedup and reassembly, in memory if Address is empty.
+
+	Smscs  []Smsc  // SMSCs to bind to, the one in Smpp if empty.
+	Routes []Route // Outbound routes by destination prefix, all SMSCs in order if none matches.
+
+	Routefile string // Least-cost routing table as CSV of prefix,smsc,price,priority, reloaded on change; overrides Routes.
+	Lookup    Lookup // Number lookup before submit.
+
+	Ratelimits []Ratelimit // Limits by destination prefix or SMSC on top of the global one.
+
+	Numberingplan string // CSV of prefix,mcc,mnc,country,operator over the built-in numbering plan.
+
+	Cdr    CDR     // Call detail records for billing.
+	Quotas []Quota // Budgets of outbound traffic.
+
+	Prices   map[string]float64 // Price per part by destination prefix, for routes without one.
+	Currency string             // Of the prices, shown in submit responses and reports.
+
+	Auditlog    string // JSON lines file of privacy actions, the log if empty.
+	Masknumbers bool   // Show phone numbers as +4917•••••89 in Telegram and logs; the store keeps them whole.
+
+	Storekey         string // Encrypts message texts in Storepath: base64 AES-256 key, "file:/path" or "env:NAME".
+	Encryptaddresses bool   // Encrypt the numbers in Storepath too.
+
+	Moderation  Moderation  // Screening of inbound SMS before they are forwarded.
+	Translation Translation // Translation of inbound SMS in foreign languages.
+}
+
+// Dns configures name resolution.
+type Dns struct {
+	Servers  []string // DNS servers ("10.0.0.53" or "10.0.0.53:53"), the system resolver if empty.
+	Cachettl Duration // How long answers are reused; stale answers stand in when lookups fail.
+}
+
+// Socks5 is a SOCKS5 proxy, unused if Host is empty.
+type Socks5 struct {
+	Host     string
+	Port     int
+	Username string // Optional.
+	Password string
+}
+
+// Duration is a time.Duration read from config either as a string
+// like "1m30s" or as a number of seconds.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		d.Duration = time.Duration(v * float64(time.Second))
+	case string:
+		var err error
+		d.Duration, err = time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// setDefaults fills in options missing from the config file.
+func (b *Bridge) setDefaults() {
+	if b.config.Apiurl == "" {
+		b.config.Apiurl = "https://api.telegram.org"
+	}
+	b.config.Apiurl = strings.TrimRight(b.config.Apiurl, "/")
+	if b.config.Connecttimeout.Duration == 0 {
+		b.config.Connecttimeout.Duration = 10 * time.Second
+	}
+	if b.config.Readtimeout.Duration == 0 {
+		b.config.Readtimeout.Duration = 30 * time.Second
+	}
+	if b.config.Servicetimeout.Duration == 0 {
+		b.config.Servicetimeout.Duration = 10 * time.Second
+	}
+	if b.config.Keepalive.Duration == 0 {
+		b.config.Keepalive.Duration = 30 * time.Second
+	}
+	if b.config.Maxidleconns == 0 {
+		b.config.Maxidleconns = 10
+	}
+	if b.config.Dns.Cachettl.Duration == 0 {
+		b.config.Dns.Cachettl.Duration = time.Minute
+	}
+	if b.config.Jwt.Tenantclaim == "" {
+		b.config.Jwt.Tenantclaim = "tenant"
+	}
+	if b.config.Jwt.Sourcesclaim == "" {
+		b.config.Jwt.Sourcesclaim = "sources"
+	}
+	if b.config.Socks5.Port == 0 {
+		b.config.Socks5.Port = 1080
+	}
+	if b.config.Queuesize == 0 {
+		b.config.Queuesize = 100
+	}
+	if b.config.Queuewait.Duration == 0 {
+		b.config.Queuewait.Duration = 5 * time.Second
+	}
+	if b.config.Inboundworkers == 0 {
+		b.config.Inboundworkers = 4
+	}
+	if b.config.Inboundqueue == 0 {
+		b.config.Inboundqueue = 100
+	}
+	if b.config.Capturesize == 0 {
+		b.config.Capturesize = 64
+	}
+	if b.config.Inboundwait.Duration == 0 {
+		b.config.Inboundwait.Duration = 2 * time.Second
+	}
+	if b.config.Submittimeout.Duration == 0 {
+		b.config.Submittimeout.Duration = 30 * time.Second
+	}
+	if b.config.Flapdelay.Duration == 0 {
+		b.config.Flapdelay.Duration = 30 * time.Second
+	}
+	if b.config.Reporttime == "" {
+		b.config.Reporttime = "08:00"
+	}
+	if b.config.Heartbeatinterval.Duration == 0 {
+		b.config.Heartbeatinterval.Duration = 24 * time.Hour
+	}
+	if b.config.Failoverafter == 0 {
+		b.config.Failoverafter = 3
+	}
+	if b.config.Alertwindow.Duration == 0 {
+		b.config.Alertwindow.Duration = 10 * time.Minute
+	}
+	if b.config.Concatwait.Duration == 0 {
+		b.config.Concatwait.Duration = 10 * time.Minute
+	}
+	if b.config.Lookup.Timeout.Duration == 0 {
+		b.config.Lookup.Timeout.Duration = 2 * time.Second
+	}
+	if b.config.Lookup.Cachettl.Duration == 0 {
+		b.config.Lookup.Cachettl.Duration = time.Hour
+	}
+	if b.config.Moderation.Timeout.Duration == 0 {
+		b.config.Moderation.Timeout.Duration = 2 * time.Second
+	}
+	if b.config.Translation.Timeout.Duration == 0 {
+		b.config.Translation.Timeout.Duration = 3 * time.Second
+	}
+	if b.config.Translation.Target == "" && len(b.config.Translation.Languages) > 0 {
+		b.config.Translation.Target = b.config.Translation.Languages[0]
+	}
+	if b.config.Ha.Ttl.Duration == 0 {
+		b.config.Ha.Ttl.Duration = 10 * time.Second
+	}
+	if b.config.Ha.Key == "" {
+		b.config.Ha.Key = "telegram-smpp-bot:leader"
+	}
+	if b.config.Ha.Instance == "" {
+		host, _ := os.Hostname()
+		b.config.Ha.Instance = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+}
+
+// LoadConfig reads the JSON config file at path.
+func LoadConfig(path string) (*Config, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := new(Config)
+	if err := json.Unmarshal(file, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
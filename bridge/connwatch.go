@@ -0,0 +1,99 @@
+package bridge
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fiorix/go-smpp/smpp"
+)
+
+// bindWatcher turns the connection status stream of a bind into ops chat
+// notifications. A DOWN notice is only posted once the bind has stayed down
+// for config.Flapdelay, so a bouncing link stays quiet; the matching
+// "restored" notice is only posted if DOWN was.
+type bindWatcher struct {
+	bridge *Bridge
+	name   string
+
+	mu        sync.Mutex
+	up        bool      // Bind is connected.
+	down      bool      // Bind is not connected.
+	since     time.Time // When it went down.
+	reason    string    // Last failure reason.
+	announced bool      // DOWN notice was posted.
+	timer     *time.Timer
+}
+
+func (b *Bridge) newBindWatcher(name string) *bindWatcher {
+	return &bindWatcher{bridge: b, name: name}
+}
+
+func (w *bindWatcher) update(c smpp.ConnStatus) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.up = c.Status() == smpp.Connected
+	if w.up {
+		if !w.down {
+			return
+		}
+		w.down = false
+		if w.timer != nil {
+			w.timer.Stop()
+		}
+		if w.announced {
+			w.announced = false
+			go w.bridge.sendOps(fmt.Sprintf("✅ SMPP bind to %s restored after %s", html.EscapeString(w.name), time.Since(w.since).Round(time.Second)))
+		} else {
+			log.Printf("SMPP bind to %s restored after %s, not notifying", w.name, time.Since(w.since).Round(time.Second))
+		}
+		return
+	}
+
+	w.reason = c.Status().String()
+	if err := c.Error(); err != nil {
+		w.reason = err.Error()
+	}
+	if c.Status() == smpp.BindFailed {
+		w.bridge.alert("smpp:bind", fmt.Sprintf("SMPP bind to %s failed: %s", w.name, w.reason))
+	}
+	if w.down {
+		return
+	}
+	w.down = true
+	w.since = time.Now()
+	w.timer = time.AfterFunc(w.bridge.config.Flapdelay.Duration, w.announce)
+}
+
+// announce posts the DOWN notice if the bind is still down.
+func (w *bindWatcher) announce() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.down || w.announced {
+		return
+	}
+	w.announced = true
+	go w.bridge.sendOps(fmt.Sprintf("⚠️ SMPP bind to %s DOWN (%s)", html.EscapeString(w.name), html.EscapeString(w.reason)))
+}
+
+func (w *bindWatcher) isUp() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.up
+}
+
+// state describes the bind for humans.
+func (w *bindWatcher) state() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch {
+	case w.up:
+		return "up"
+	case w.down:
+		return fmt.Sprintf("down for %s (%s)", time.Since(w.since).Round(time.Second), w.reason)
+	}
+	return "connecting"
+}
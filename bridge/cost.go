@@ -0,0 +1,87 @@
+package bridge
+
+import (
+	"expvar"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spendToday is the estimated cost of today's outbound SMS per API
+// identity, exported on /debug/vars as spend_today.
+type spendToday struct {
+	sync.Mutex
+	day      string
+	byTenant map[string]float64
+}
+
+func init() {
+	expvar.Publish("spend_today", expvar.Func(func() interface{} {
+		m := make(map[string]float64)
+		eachRunning(func(b *Bridge) {
+			b.spend.Lock()
+			defer b.spend.Unlock()
+			b.rollSpend(time.Now())
+			for k, v := range b.spend.byTenant {
+				m[k] += v
+			}
+		})
+		return m
+	}))
+}
+
+// priceOf returns the price per part of an SMS to dst: the one of the
+// route if the route table has it, free routes included, else that of the
+// longest prefix of dst in config.Prices.
+func (b *Bridge) priceOf(dst string, h hop) float64 {
+	if h.priced {
+		return h.price
+	}
+	dst = strings.TrimPrefix(dst, "+")
+	best, price := -1, 0.0
+	for p, v := range b.config.Prices {
+		p = strings.TrimPrefix(p, "+")
+		if strings.HasPrefix(dst, p) && len(p) > best {
+			best, price = len(p), v
+		}
+	}
+	return price
+}
+
+// tenantKey names the API identity of m in spend metrics and reports.
+func tenantKey(m *Message) string {
+	if m.Tenant == "" {
+		return "none"
+	}
+	return m.Tenant
+}
+
+// addSpend counts the cost of m to today's spend.
+func (b *Bridge) addSpend(m *Message) {
+	b.spend.Lock()
+	defer b.spend.Unlock()
+	b.rollSpend(m.Time)
+	b.spend.byTenant[tenantKey(m)] += m.Cost
+}
+
+// rollSpend starts a new day of spend if t is on another day. Must be
+// called with spend held.
+func (b *Bridge) rollSpend(t time.Time) {
+	if day := t.Format("2006-01-02"); day != b.spend.day {
+		b.spend.day, b.spend.byTenant = day, make(map[string]float64)
+	}
+}
+
+// initSpend adds up today's spend so far from the store.
+func (b *Bridge) initSpend() {
+	now := time.Now()
+	day, start := periodOf("daily", now)
+	b.spend.Lock()
+	b.spend.day = day
+	b.spend.Unlock()
+	for _, m := range b.store.Between(start, now) {
+		if m.Cost > 0 {
+			b.addSpend(&m)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type dnsCache struct {
+	r   *net.Resolver
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// initResolver builds resolver from config.
+func (b *Bridge) initResolver() {
+	r := net.DefaultResolver
+	if servers := b.config.Dns.Servers; len(servers) > 0 {
+		for i, s := range servers {
+			if _, _, err := net.SplitHostPort(s); err != nil {
+				servers[i] = net.JoinHostPort(s, "53")
+			}
+		}
+		var next uint32
+		r = &net.Resolver{
+			PreferGo: true,
+			// Spread queries over the servers, moving on when one can't
+			// be dialed.
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				start := int(atomic.AddUint32(&next, 1))
+				var err error
+				for i := range servers {
+					var conn net.Conn
+					conn, err = d.DialContext(ctx, network, servers[(start+i)%len(servers)])
+					if err == nil {
+						return conn, nil
+					}
+				}
+				return nil, err
+			},
+		}
+	}
+	b.resolver = &dnsCache{r: r, ttl: b.config.Dns.Cachettl.Duration, entries: make(map[string]dnsEntry)}
+}
+
+// LookupHost returns the addresses of host. IP literals are returned as
+// is.
+func (c *dnsCache) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		return e.addrs, nil
+	}
+
+	addrs, err := c.r.LookupHost(ctx, host)
+	if err != nil {
+		if ok {
+			log.Printf("Can't resolve %s, using cached %v. Error: %s", host, e.addrs, err)
+			return e.addrs, nil
+		}
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[host] = dnsEntry{addrs: addrs, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return addrs, nil
+}
+
+// LookupSRV looks up SRV records through the configured servers,
+// uncached.
+func (c *dnsCache) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return c.r.LookupSRV(ctx, service, proto, name)
+}
+
+// dialContext returns a dial function for http.Transport that resolves
+// through the cache and tries each address in turn.
+func (c *dnsCache) dialContext(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := c.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		errs := make([]error, 0, len(addrs))
+		for _, a := range addrs {
+			conn, err := d.DialContext(ctx, network, net.JoinHostPort(a, port))
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+		return nil, errors.Join(errs...)
+	}
+}
@@ -0,0 +1,119 @@
+package bridge
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"telegram-smpp-bot/api"
+)
+
+// exportFields are the CSV columns of an export.
+var exportFields = []string{"id", "uuid", "timestamp", "direction", "src", "dst", "text", "parts", "encoding",
+	"smsc_id", "smsc", "route", "price", "cost", "status", "error", "tenant", "mcc", "mnc", "country"}
+
+// parseTime reads an RFC 3339 time or a date, which means its midnight UTC.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func (b *Bridge) registerExport() {
+	// GET /api/v2/messages/export?format=csv|jsonl&from=...&to=... streams
+	// the messages stored in [from, to). A tenant only gets its own.
+	b.handle(api.GroupAPI, "/api/v2/messages/export", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		from, to := time.Time{}, time.Now()
+		var err error
+		if v := q.Get("from"); v != "" {
+			if from, err = parseTime(v); err != nil {
+				http.Error(w, "Bad from: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if v := q.Get("to"); v != "" {
+			if to, err = parseTime(v); err != nil {
+				http.Error(w, "Bad to: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		format := q.Get("format")
+		switch format {
+		case "", "csv":
+			format = "csv"
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		case "jsonl":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		default:
+			http.Error(w, "Unknown format "+strconv.Quote(format), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="messages-%s.%s"`, from.Format("20060102"), format))
+
+		id := identityOf(r)
+		cw := csv.NewWriter(w)
+		enc := json.NewEncoder(w)
+		if format == "csv" {
+			cw.Write(exportFields)
+		}
+		for i, m := range b.store.Between(from, to) {
+			if id != nil && id.Tenant != m.Tenant {
+				continue
+			}
+			if format == "jsonl" {
+				if err := enc.Encode(&m); err != nil {
+					return
+				}
+				continue
+			}
+			rec := make([]string, len(exportFields))
+			for j, f := range exportFields {
+				rec[j] = cdrValue(f, &m)
+			}
+			cw.Write(rec)
+			if i%1000 == 999 {
+				cw.Flush()
+				if cw.Error() != nil {
+					return
+				}
+			}
+		}
+		cw.Flush()
+	})
+	// GET /api/v2/messages/{uuid} returns a stored message, with its
+	// status and the SMSC and Telegram ids it maps to. Store IDs work
+	// too. A tenant only gets its own.
+	b.handle(api.GroupAPI, "/api/v2/messages/", func(w http.ResponseWriter, r *http.Request) {
+		ref := strings.TrimPrefix(r.URL.Path, "/api/v2/messages/")
+		if ref == "" || strings.Contains(ref, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		m, ok := b.store.Lookup(ref)
+		if id := identityOf(r); ok && id != nil && id.Tenant != m.Tenant && !b.isAdmin(r) && !b.mayManageSubjects(r) {
+			ok = false
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m)
+	})
+}
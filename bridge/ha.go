@@ -0,0 +1,325 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/fiorix/go-smpp/smpp"
+
+	"telegram-smpp-bot/api"
+)
+
+// HA runs two or more instances of which only the elected leader binds to
+// the SMSC, serves submits and handles Telegram updates.
+type HA struct {
+	Lock     string   // "file", "redis", "primary" or "standby", off if empty.
+	Lockfile string   // Lease file on storage shared by all instances.
+	Primary  string   // Readiness URL of the primary in standby mode, like http://sms-a:8090/readyz.
+	Standby  string   // Readiness URL of the standby in primary mode, like http://sms-b:8090/readyz.
+	Redis    Redis    // Server holding the lease key.
+	Key      string   // Redis key of the lease.
+	Ttl      Duration // Lease lifetime, or how long a standby waits for an unready primary.
+	Instance string   // Name of this instance, host name and PID if empty.
+}
+
+// errNotLeader is returned by sendSMS on a follower.
+var errNotLeader = errors.New("not the leader")
+
+func (b *Bridge) isLeader() bool { return b.leader.Load() }
+
+// locker takes or renews the lease for instance and reports whether this
+// instance holds it.
+type locker interface {
+	acquire(ctx context.Context, instance string, ttl time.Duration) (bool, error)
+}
+
+// startHA binds right away without HA, else elects a leader and binds only
+// while this instance is it.
+func (b *Bridge) startHA(ctx context.Context, handler smpp.HandlerFunc) error {
+	if err := b.initBinds(handler); err != nil {
+		return err
+	}
+	if b.config.Ha.Lock == "" {
+		b.leader.Store(true)
+		b.bindAll()
+		return nil
+	}
+	var l locker
+	switch b.config.Ha.Lock {
+	case "file":
+		if b.config.Ha.Lockfile == "" {
+			return errors.New("HA file lock needs lockfile")
+		}
+		l = fileLock(b.config.Ha.Lockfile)
+	case "redis":
+		if b.config.Ha.Redis.Address == "" {
+			return errors.New("HA redis lock needs redis address")
+		}
+		l = &redisLock{c: newRedisClient(b.config.Ha.Redis), key: b.config.Ha.Key}
+	case "primary":
+		if b.config.Ha.Standby == "" {
+			return errors.New("HA primary needs standby")
+		}
+	case "standby":
+		if b.config.Ha.Primary == "" {
+			return errors.New("HA standby needs primary")
+		}
+	default:
+		return fmt.Errorf("unknown HA lock %q", b.config.Ha.Lock)
+	}
+	log.Printf("Instance %s waiting for SMPP leadership", b.config.Ha.Instance)
+	switch {
+	case b.config.Ha.Lock == "primary":
+		go supervise(ctx, "primary", b.primary)
+		return nil
+	case l == nil:
+		go supervise(ctx, "standby", b.standby)
+		return nil
+	}
+	go supervise(ctx, "leader election", func(ctx context.Context) { b.elect(ctx, l) })
+	return nil
+}
+
+// primary binds unless the standby is ready, that is serving in its
+// place, and checks it again every third of config.Ha.Ttl. A standby that
+// took over hands back once the primary answers again, see standby.
+func (b *Bridge) primary(ctx context.Context) {
+	ttl := b.config.Ha.Ttl.Duration
+	c := &http.Client{Timeout: ttl / 3}
+	for {
+		err := b.checkReady(ctx, c, b.config.Ha.Standby)
+		if err != nil && !b.isLeader() {
+			b.takeOver("standby not ready: " + err.Error())
+		}
+		if !sleep(ctx, ttl/3) {
+			return
+		}
+	}
+}
+
+// standby checks the primary every third of config.Ha.Ttl and takes over
+// once it has not been ready for all of config.Ha.Ttl. It hands back once
+// the primary has answered, ready or not, for config.Ha.Ttl: a primary
+// with Lock "primary" waits for the standby to step down before binding.
+func (b *Bridge) standby(ctx context.Context) {
+	ttl := b.config.Ha.Ttl.Duration
+	c := &http.Client{Timeout: ttl / 3}
+	healthy, down := time.Now(), time.Now()
+	for {
+		err := b.checkReady(ctx, c, b.config.Ha.Primary)
+		var notReady *notReadyError
+		switch {
+		case err == nil:
+			healthy = time.Now()
+		case errors.As(err, &notReady) && b.isLeader():
+		default:
+			down = time.Now()
+			if !b.isLeader() {
+				log.Printf("Primary %s not ready. Error: %s", b.config.Ha.Primary, err)
+			}
+		}
+		if b.isLeader() {
+			if time.Since(down) >= ttl {
+				b.stepDown("primary is back")
+				healthy = time.Now()
+			}
+		} else if time.Since(healthy) >= ttl {
+			b.takeOver(fmt.Sprintf("primary not ready for %s: %s", ttl, err))
+			down = time.Now()
+		}
+		if !sleep(ctx, ttl/3) {
+			return
+		}
+	}
+}
+
+// notReadyError is an answer of a readiness URL other than 200 OK.
+type notReadyError struct {
+	status string
+}
+
+func (e *notReadyError) Error() string {
+	return e.status
+}
+
+// checkReady asks the readiness URL of the other instance.
+func (b *Bridge) checkReady(ctx context.Context, c *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return &notReadyError{resp.Status}
+	}
+	return nil
+}
+
+// elect renews or tries to take the lease every third of its lifetime.
+// A leader that can't renew steps down before its lease can expire, so
+// two instances never bind at once.
+func (b *Bridge) elect(ctx context.Context, l locker) {
+	ttl := b.config.Ha.Ttl.Duration
+	var renewed time.Time
+	for {
+		ok, err := l.acquire(ctx, b.config.Ha.Instance, ttl)
+		switch {
+		case err != nil:
+			log.Printf("Can't renew SMPP leadership. Error: %s", err)
+			if b.isLeader() && time.Since(renewed) > ttl*2/3 {
+				b.stepDown("lease lost: " + err.Error())
+			}
+		case ok:
+			renewed = time.Now()
+			if !b.isLeader() {
+				b.takeOver("lease acquired")
+			}
+		case b.isLeader():
+			b.stepDown("lease taken by another instance")
+		}
+		if !sleep(ctx, ttl/3) {
+			return
+		}
+	}
+}
+
+func (b *Bridge) takeOver(reason string) {
+	log.Printf("Instance %s is now the SMPP leader: %s", b.config.Ha.Instance, reason)
+	b.leader.Store(true)
+	b.bindAll()
+	go b.sendOps(fmt.Sprintf("👑 %s is now the SMPP leader (%s)", html.EscapeString(b.config.Ha.Instance), html.EscapeString(reason)))
+}
+
+func (b *Bridge) stepDown(reason string) {
+	log.Printf("Instance %s steps down as SMPP leader: %s", b.config.Ha.Instance, reason)
+	b.leader.Store(false)
+	b.unbindAll()
+	go b.sendOps(fmt.Sprintf("⏬ %s stepped down as SMPP leader (%s)", html.EscapeString(b.config.Ha.Instance), html.EscapeString(reason)))
+}
+
+// fileLock is a lease file holding the leader and the expiry of its lease.
+// It is replaced atomically by rename, so it has to live on a file system
+// both instances see, like NFS.
+type fileLock string
+
+type lease struct {
+	Instance string
+	Expires  time.Time
+}
+
+func (f fileLock) acquire(ctx context.Context, instance string, ttl time.Duration) (bool, error) {
+	cur, err := f.read()
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	mine := cur.Instance == instance
+	if !mine && time.Now().Before(cur.Expires) {
+		return false, nil
+	}
+	if err := f.write(lease{instance, time.Now().Add(ttl)}); err != nil {
+		return false, err
+	}
+	if !mine {
+		// Two instances may have seen the lease expire; the last rename
+		// wins, so give the other one time to land before checking.
+		if !sleep(ctx, ttl/10) {
+			return false, ctx.Err()
+		}
+	}
+	cur, err = f.read()
+	if err != nil {
+		return false, err
+	}
+	return cur.Instance == instance, nil
+}
+
+func (f fileLock) read() (lease, error) {
+	var l lease
+	b, err := os.ReadFile(string(f))
+	if err != nil {
+		return l, err
+	}
+	return l, json.Unmarshal(b, &l)
+}
+
+func (f fileLock) write(l lease) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(string(f)), ".lease-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), string(f))
+}
+
+// redisLock is a lease key set to the leader's name with an expiry.
+type redisLock struct {
+	c   *redisClient
+	key string
+}
+
+// acquireScript takes the key if it is free and extends it if it is ours.
+const acquireScript = `
+local v = redis.call('GET', KEYS[1])
+if v == false then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+end
+if v == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+return 0`
+
+func (r *redisLock) acquire(ctx context.Context, instance string, ttl time.Duration) (bool, error) {
+	v, err := r.c.do(ctx, "EVAL", acquireScript, "1", r.key, instance, strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return false, err
+	}
+	n, _ := v.(int64)
+	return n == 1, nil
+}
+
+func (b *Bridge) registerHealth() {
+	b.handle(api.GroupHealth, "/healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	// Load balancers route to the instance that is ready, which is the
+	// leader once one of its binds is up.
+	b.handle(api.GroupHealth, "/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !b.isLeader() {
+			http.Error(w, "not the leader", http.StatusServiceUnavailable)
+			return
+		}
+		if !b.anyUp() {
+			http.Error(w, "no SMPP bind up", http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok")
+	})
+}
@@ -0,0 +1,80 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// heartbeat posts a liveness message with the traffic of the last interval
+// to the heartbeat chat, so a quiet channel can be told apart from a dead
+// bridge. The first message is sent at config.Heartbeattime if set, else
+// one interval after start.
+func (b *Bridge) heartbeat(ctx context.Context) {
+	interval := b.config.Heartbeatinterval.Duration
+	next := time.Now().Add(interval)
+	if b.config.Heartbeattime != "" {
+		at, err := nextAt(b.config.Heartbeattime, time.Now())
+		if err != nil {
+			log.Printf("Bad heartbeattime %q, using interval only. Error: %s", b.config.Heartbeattime, err)
+		} else {
+			next = at
+		}
+	}
+	prev := snapshot()
+	for {
+		if !sleep(ctx, time.Until(next)) {
+			return
+		}
+		next = next.Add(interval)
+
+		cur := snapshot()
+		d := cur.since(prev)
+		prev = cur
+		if !b.isLeader() {
+			continue
+		}
+		m := fmt.Sprintf("✅ gateway alive — %d in / %d out / %d errors in the last %s", d.in, d.out, d.errs, formatPeriod(interval))
+		if err := b.sendTo(ctx, b.config.Heartbeatchat, b.config.Heartbeattopic, m); err != nil {
+			log.Printf("Can't send heartbeat to Telegram. Error: %s", err)
+			errsTotal.Add(1)
+		}
+	}
+}
+
+// nextAt returns the first moment after now with the wall clock time hhmm
+// ("15:04") in the local time zone.
+func nextAt(hhmm string, now time.Time) (time.Time, error) {
+	h, m, ok := strings.Cut(hhmm, ":")
+	if !ok {
+		return time.Time{}, fmt.Errorf("want HH:MM")
+	}
+	hour, err := strconv.Atoi(h)
+	if err != nil || hour < 0 || hour > 23 {
+		return time.Time{}, fmt.Errorf("bad hour %q", h)
+	}
+	minute, err := strconv.Atoi(m)
+	if err != nil || minute < 0 || minute > 59 {
+		return time.Time{}, fmt.Errorf("bad minute %q", m)
+	}
+	t := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// formatPeriod renders whole hours and minutes as "24h" or "10m" rather
+// than "24h0m0s".
+func formatPeriod(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0 && d < time.Hour:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return d.String()
+}
@@ -0,0 +1,130 @@
+package bridge
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"time"
+)
+
+// Inbound deliveries dropped because the forwarding queue was full.
+var inboundDropped = expvar.NewInt("inbound_dropped")
+
+func init() {
+	expvar.Publish("inbound_queue_depth", expvar.Func(func() interface{} {
+		n := 0
+		eachRunning(func(b *Bridge) { n += b.inboundDepth() })
+		return n
+	}))
+}
+
+// inbound is a decoded deliver_sm waiting to be forwarded.
+type inbound struct {
+	src, dst, text string
+	receipt        bool // A delivery receipt rather than an SMS.
+}
+
+// startInbound sizes the queues and starts the workers, which stop when
+// ctx is done.
+func (b *Bridge) startInbound(ctx context.Context) error {
+	switch b.config.Inboundoverflow {
+	case "":
+		b.config.Inboundoverflow = "block"
+	case "block", "drop":
+	default:
+		return fmt.Errorf("unknown inboundoverflow %q", b.config.Inboundoverflow)
+	}
+	n := 1
+	if b.config.Inboundordered {
+		n = b.config.Inboundworkers
+	}
+	// Each ordered queue holds a full config.Inboundqueue: a busy sender
+	// fills only its own, and must not hit the overflow policy n times
+	// sooner than it would on a shared queue.
+	b.inboundQueues = make([]chan inbound, n)
+	for i := range b.inboundQueues {
+		b.inboundQueues[i] = make(chan inbound, b.config.Inboundqueue)
+	}
+	for i := 0; i < b.config.Inboundworkers; i++ {
+		q := b.inboundQueues[i%n]
+		go supervise(ctx, "inbound worker", func(ctx context.Context) { b.forwardInbound(ctx, q) })
+	}
+	return nil
+}
+
+// queueFor returns the queue of deliveries from src. Hashing the sender
+// keeps its messages on one worker in arrival order.
+func (b *Bridge) queueFor(src string) chan inbound {
+	if len(b.inboundQueues) == 1 {
+		return b.inboundQueues[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(src))
+	return b.inboundQueues[h.Sum32()%uint32(len(b.inboundQueues))]
+}
+
+// inboundDepth returns the number of queued deliveries.
+func (b *Bridge) inboundDepth() int {
+	n := 0
+	for _, q := range b.inboundQueues {
+		n += len(q)
+	}
+	return n
+}
+
+// inboundCapacity returns the number of deliveries the queues hold.
+func (b *Bridge) inboundCapacity() int {
+	n := 0
+	for _, q := range b.inboundQueues {
+		n += cap(q)
+	}
+	return n
+}
+
+// enqueueInbound hands in to the workers. When the queue is full it waits
+// up to config.Inboundwait, holding back the deliver_sm_resp so the SMSC
+// slows down, or with config.Inboundoverflow "drop" not at all; then it
+// drops in and alerts. go-smpp runs this in the read loop of the bind, so
+// while it waits no submit_sm_resp or enquire_link_resp gets through
+// either, and the wait must stay well below their timeouts.
+func (b *Bridge) enqueueInbound(ctx context.Context, in inbound) {
+	q := b.queueFor(in.src)
+	select {
+	case q <- in:
+		return
+	default:
+	}
+	if b.config.Inboundoverflow != "drop" {
+		t := time.NewTimer(b.config.Inboundwait.Duration)
+		defer t.Stop()
+		select {
+		case q <- in:
+			return
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+	inboundDropped.Add(1)
+	errsTotal.Add(1)
+	log.Printf("Dropped deliver_sm from %s to %s, inbound queue is full", b.mask(in.src), b.mask(in.dst))
+	b.alert("inbound", fmt.Sprintf("Dropped SMS from %s to %s, inbound queue is full (%d)", b.mask(in.src), b.mask(in.dst), cap(q)))
+}
+
+// forwardInbound forwards the deliveries queued in q until ctx is done.
+func (b *Bridge) forwardInbound(ctx context.Context, q chan inbound) {
+	for {
+		select {
+		case in := <-q:
+			if in.receipt {
+				b.handleReceipt(ctx, in.src, in.dst, in.text)
+			} else {
+				b.forwardSMS(ctx, in.src, in.dst, in.text)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
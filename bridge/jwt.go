@@ -0,0 +1,304 @@
+package bridge
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWT configures bearer token authentication with tokens issued by an
+// OIDC provider. Tokens are checked against the provider's JWKS.
+type JWT struct {
+	Issuer       string
+	Audience     string
+	Jwksurl      string
+	Tenantclaim  string   // Claim naming the tenant, "tenant" if empty.
+	Sourcesclaim string   // Claim listing allowed source addresses, "sources" if empty.
+	Leeway       Duration // Allowed clock skew for exp and nbf.
+}
+
+// jwkSet holds the signing keys of the issuer by key ID.
+type jwkSet struct {
+	sync.Mutex
+	keys     map[string]crypto.PublicKey
+	fetched  time.Time
+	err      error         // Of the last fetch.
+	fetching chan struct{} // Closed when the fetch in progress is done, nil if there is none.
+}
+
+// How often the key set is refreshed, and how often at most when a token
+// names an unknown key.
+const (
+	jwksRefresh    = time.Hour
+	jwksMinRefresh = time.Minute
+)
+
+// looksLikeJWT tells a JWT from a static API key.
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// verifyJWT checks the signature and the claims of token and returns the
+// identity it carries.
+func (b *Bridge) verifyJWT(token string) (*identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("bad header: %w", err)
+	}
+	key, err := b.jwtKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("bad signature encoding: %w", err)
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("bad claims: %w", err)
+	}
+	c := b.config.Jwt
+	now := time.Now()
+	if iss, _ := claims["iss"].(string); c.Issuer != "" && iss != c.Issuer {
+		return nil, fmt.Errorf("wrong issuer %q", iss)
+	}
+	if c.Audience != "" && !hasAudience(claims["aud"], c.Audience) {
+		return nil, errors.New("wrong audience")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(c.Leeway.Duration)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(c.Leeway.Duration).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token not valid yet")
+	}
+
+	tenant, _ := claims[c.Tenantclaim].(string)
+	if tenant == "" {
+		return nil, fmt.Errorf("no %q claim", c.Tenantclaim)
+	}
+	// Any token of the issuer can name any tenant, so the powerful ones
+	// only take the subjects they list.
+	if t := b.config.Tenants[tenant]; t.Admin || t.Privacy {
+		sub, _ := claims["sub"].(string)
+		if !t.allowsSubject(sub) {
+			return nil, fmt.Errorf("subject %q may not act as tenant %q", sub, tenant)
+		}
+	}
+	id := &identity{Tenant: tenant}
+	switch v := claims[c.Sourcesclaim].(type) {
+	case string:
+		id.Sources = strings.Fields(v)
+	case []interface{}:
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				id.Sources = append(id.Sources, s)
+			}
+		}
+	}
+	return id, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func hasAudience(aud interface{}, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, a := range v {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifySignature checks sig over signed with the algorithm alg.
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	var h crypto.Hash
+	switch alg[2:] {
+	case "256":
+		h = crypto.SHA256
+	case "384":
+		h = crypto.SHA384
+	case "512":
+		h = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	hasher := h.New()
+	hasher.Write([]byte(signed))
+	digest := hasher.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(k, h, digest, sig)
+		case "PS":
+			return rsa.VerifyPSS(k, h, digest, sig, nil)
+		}
+	case *ecdsa.PublicKey:
+		if alg[:2] != "ES" {
+			break
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("bad signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("algorithm %q doesn't match the key", alg)
+}
+
+// jwtKey returns the issuer's key kid, refreshing the key set when it is
+// old or doesn't know kid. One request fetches the set while the others
+// wait for it, or go on with the old key if they have one.
+func (b *Bridge) jwtKey(kid string) (crypto.PublicKey, error) {
+	b.jwks.Lock()
+	key, ok := b.jwks.keys[kid]
+	age := time.Since(b.jwks.fetched)
+	fetching := b.jwks.fetching
+	switch {
+	case ok && (age < jwksRefresh || fetching != nil):
+		b.jwks.Unlock()
+		return key, nil
+	case !ok && age < jwksMinRefresh:
+		b.jwks.Unlock()
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if fetching == nil {
+		fetching = make(chan struct{})
+		b.jwks.fetching = fetching
+		b.jwks.Unlock()
+		b.refreshJWKS(fetching)
+	} else {
+		b.jwks.Unlock()
+		<-fetching
+	}
+
+	b.jwks.Lock()
+	defer b.jwks.Unlock()
+	if key, ok = b.jwks.keys[kid]; ok {
+		return key, nil
+	}
+	if b.jwks.err != nil {
+		return nil, fmt.Errorf("can't fetch JWKS: %w", b.jwks.err)
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// refreshJWKS fetches the key set, without holding the lock, and closes
+// done once the keys are swapped in. Failures keep the old keys.
+func (b *Bridge) refreshJWKS(done chan struct{}) {
+	keys, err := b.fetchJWKS(b.config.Jwt.Jwksurl)
+	b.jwks.Lock()
+	defer b.jwks.Unlock()
+	if err != nil {
+		log.Printf("Can't refresh JWKS, keeping the old keys. Error: %s", err)
+	} else {
+		b.jwks.keys = keys
+		b.jwks.fetched = time.Now()
+	}
+	b.jwks.err = err
+	b.jwks.fetching = nil
+	close(done)
+}
+
+// fetchJWKS downloads a JSON Web Key Set and returns its RSA and EC keys.
+func (b *Bridge) fetchJWKS(url string) (map[string]crypto.PublicKey, error) {
+	resp, err := b.jwksClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return keys, nil
+}
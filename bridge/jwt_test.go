@@ -0,0 +1,159 @@
+package bridge
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testIssuer = "https://issuer.example"
+
+// jwksServer serves key as "k1" in a JWKS and counts the fetches.
+func jwksServer(t *testing.T, key *ecdsa.PrivateKey) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var fetches atomic.Int32
+	coord := func(n interface{ FillBytes([]byte) []byte }) string {
+		return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, 32)))
+	}
+	set, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "EC", "kid": "k1", "crv": "P-256", "x": coord(key.X), "y": coord(key.Y),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write(set)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &fetches
+}
+
+// signJWT returns an ES256 token with the claims, signed by key as kid.
+func signJWT(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	seg := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := seg(map[string]string{"alg": "ES256", "kid": kid}) + "." + seg(claims)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func jwtConfig(jwksURL string) *Config {
+	return &Config{
+		Jwt: JWT{Issuer: testIssuer, Jwksurl: jwksURL},
+		Tenants: map[string]Tenant{
+			"t":   {Sources: []string{"TEST"}},
+			"ops": {Admin: true, Subjects: []string{"ops-automation"}},
+		},
+	}
+}
+
+func TestJWT(t *testing.T) {
+	key, other := newECKey(t), newECKey(t)
+	exp := time.Now().Add(time.Hour).Unix()
+	claims := func(tenant, iss, sub string, exp int64) map[string]interface{} {
+		c := map[string]interface{}{"iss": iss, "exp": exp, "tenant": tenant, "sources": []string{"TEST"}}
+		if sub != "" {
+			c["sub"] = sub
+		}
+		return c
+	}
+	const submit, settings = "/", "/api/v2/admin/settings"
+
+	tests := []struct {
+		name   string
+		key    *ecdsa.PrivateKey
+		kid    string
+		claims map[string]interface{}
+		path   string
+		status int
+	}{
+		{"valid", key, "k1", claims("t", testIssuer, "", exp), submit, http.StatusOK},
+		{"expired", key, "k1", claims("t", testIssuer, "", time.Now().Add(-time.Hour).Unix()), submit, http.StatusUnauthorized},
+		{"wrong issuer", key, "k1", claims("t", "https://other.example", "", exp), submit, http.StatusUnauthorized},
+		{"unknown key", key, "k2", claims("t", testIssuer, "", exp), submit, http.StatusUnauthorized},
+		{"bad signature", other, "k1", claims("t", testIssuer, "", exp), submit, http.StatusUnauthorized},
+		{"admin without subject", key, "k1", claims("ops", testIssuer, "", exp), settings, http.StatusUnauthorized},
+		{"admin with another subject", key, "k1", claims("ops", testIssuer, "someone", exp), settings, http.StatusUnauthorized},
+		{"admin", key, "k1", claims("ops", testIssuer, "ops-automation", exp), settings, http.StatusOK},
+		{"not an admin", key, "k1", claims("t", testIssuer, "", exp), settings, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, fetches := jwksServer(t, key)
+			tb := startBridge(t, jwtConfig(srv.URL))
+			tb.apikey = signJWT(t, tt.key, tt.kid, tt.claims)
+
+			method, form := http.MethodGet, url.Values(nil)
+			if tt.path == submit {
+				method, form = http.MethodPost, url.Values{"src": {"TEST"}, "dst": {"+4915112345678"}, "text": {"hi"}}
+			}
+			resp, body := tb.do(t, method, tt.path, form)
+			if resp.StatusCode != tt.status {
+				t.Errorf("Got %s %s, want %d", resp.Status, body, tt.status)
+			}
+			// An unknown key is looked up once, not on every request.
+			tb.do(t, method, tt.path, form)
+			if n := fetches.Load(); n != 1 {
+				t.Errorf("Got %d JWKS fetches, want 1", n)
+			}
+		})
+	}
+}
+
+func TestJWKSFetchedOnce(t *testing.T) {
+	key := newECKey(t)
+	srv, fetches := jwksServer(t, key)
+	tb := startBridge(t, jwtConfig(srv.URL))
+	token := signJWT(t, key, "k1", map[string]interface{}{"iss": testIssuer, "exp": time.Now().Add(time.Hour).Unix(), "tenant": "t"})
+
+	var wg sync.WaitGroup
+	ids := make([]*identity, 10)
+	errs := make([]error, len(ids))
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids[i], errs[i] = tb.verifyJWT(token)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil || ids[i].Tenant != "t" {
+			t.Errorf("Got %+v, %v, want tenant t", ids[i], err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("Got %d JWKS fetches for concurrent requests, want 1", n)
+	}
+}
@@ -0,0 +1,30 @@
+package bridge
+
+import (
+	"net/http"
+
+	"telegram-smpp-bot/api"
+)
+
+// handle registers a handler for pattern in a handler group.
+func (b *Bridge) handle(group, pattern string, handler http.HandlerFunc) {
+	b.server.Handle(group, pattern, handler)
+}
+
+// listeners returns the configured listeners, or a single one on
+// config.Address serving the API, plus the webhook in webhook mode and the
+// probes the other instance polls in primary or standby HA mode. Metrics
+// are only served on a listener configured for them.
+func (b *Bridge) listeners() []api.Listener {
+	if len(b.config.Listeners) > 0 {
+		return b.config.Listeners
+	}
+	serve := []string{api.GroupAPI}
+	if b.config.Updates == "webhook" {
+		serve = append(serve, api.GroupTelegram)
+	}
+	if b.config.Ha.Lock == "primary" || b.config.Ha.Lock == "standby" {
+		serve = append(serve, api.GroupHealth)
+	}
+	return []api.Listener{{Address: b.config.Address, Serve: serve}}
+}
@@ -0,0 +1,170 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-smpp-bot/api"
+)
+
+// Lookup is an HTTP number lookup service (MNP/HLR style) asked about
+// every destination before submit.
+type Lookup struct {
+	Url      string            // "{msisdn}" is replaced by the number, else ?msisdn= is added. Off if empty.
+	Headers  map[string]string // Sent along, for credentials.
+	Timeout  Duration          // Past this the SMS goes out without lookup.
+	Cachettl Duration          // How long answers are reused.
+}
+
+// lookupResult is the answer of the lookup service. All fields are
+// optional.
+type lookupResult struct {
+	Valid     *bool  `json:"valid,omitempty"`     // false rejects the number.
+	Msisdn    string `json:"msisdn,omitempty"`    // Number to send to instead.
+	Smsc      string `json:"smsc,omitempty"`      // SMSC to prefer.
+	Reachable *bool  `json:"reachable,omitempty"` // Handset attached to the network.
+	Ported    bool   `json:"ported,omitempty"`
+	Mcc       string `json:"mcc,omitempty"`
+	Mnc       string `json:"mnc,omitempty"`
+	Operator  string `json:"operator,omitempty"`
+	Country   string `json:"country,omitempty"`
+}
+
+// lookupCache keeps lookup results until they expire.
+type lookupCache struct {
+	sync.Mutex
+	m     map[string]lookupEntry
+	swept time.Time
+}
+
+type lookupEntry struct {
+	r       *lookupResult
+	expires time.Time
+}
+
+// lookupNumber asks the lookup service about msisdn, or the cache if it
+// was asked recently. It returns nil without a lookup service.
+func (b *Bridge) lookupNumber(ctx context.Context, msisdn string) (*lookupResult, error) {
+	if b.config.Lookup.Url == "" {
+		return nil, nil
+	}
+	now := time.Now()
+	b.lookups.Lock()
+	e, ok := b.lookups.m[msisdn]
+	b.lookups.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.r, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.config.Lookup.Timeout.Duration)
+	defer cancel()
+	u := b.config.Lookup.Url
+	if strings.Contains(u, "{msisdn}") {
+		u = strings.ReplaceAll(u, "{msisdn}", url.PathEscape(msisdn))
+	} else if strings.Contains(u, "?") {
+		u += "&msisdn=" + url.QueryEscape(msisdn)
+	} else {
+		u += "?msisdn=" + url.QueryEscape(msisdn)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range b.config.Lookup.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := b.serviceClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("lookup service: %s", resp.Status)
+	}
+	r := new(lookupResult)
+	if err := json.NewDecoder(resp.Body).Decode(r); err != nil {
+		return nil, fmt.Errorf("lookup service: %w", err)
+	}
+
+	b.lookups.Lock()
+	defer b.lookups.Unlock()
+	if now.Sub(b.lookups.swept) > time.Minute {
+		b.lookups.swept = now
+		for k, e := range b.lookups.m {
+			if now.After(e.expires) {
+				delete(b.lookups.m, k)
+			}
+		}
+	}
+	b.lookups.m[msisdn] = lookupEntry{r, now.Add(b.config.Lookup.Cachettl.Duration)}
+	return r, nil
+}
+
+// checkNumber applies the lookup to an outbound SMS: it rejects invalid
+// numbers, rewrites the destination and returns the SMSC to prefer. A
+// failed lookup lets the SMS go out as it is.
+func (b *Bridge) checkNumber(ctx context.Context, m *Message) (prefer string, err error) {
+	r, err := b.lookupNumber(ctx, m.Dst)
+	if err != nil {
+		log.Printf("Can't look up %s, sending as is. Error: %s", b.mask(m.Dst), err)
+		return "", nil
+	}
+	if r == nil {
+		return "", nil
+	}
+	if r.Valid != nil && !*r.Valid {
+		return "", fmt.Errorf("%s is not a valid number", b.mask(m.Dst))
+	}
+	if r.Mcc != "" {
+		m.Mcc, m.Mnc, m.Country = r.Mcc, r.Mnc, r.Country
+	}
+	if r.Msisdn != "" && r.Msisdn != m.Dst {
+		log.Printf("Lookup rewrites %s to %s", b.mask(m.Dst), b.mask(r.Msisdn))
+		m.Dst = r.Msisdn
+	}
+	if r.Smsc != "" && b.smscNamed[r.Smsc] == nil {
+		log.Printf("Lookup of %s names unknown SMSC %q, ignoring it", b.mask(m.Dst), r.Smsc)
+		return "", nil
+	}
+	return r.Smsc, nil
+}
+
+func (b *Bridge) registerLookup() {
+	// GET /api/v2/lookup/{msisdn} returns what the lookup service knows
+	// about a number, from the cache if it was asked recently.
+	b.handle(api.GroupAPI, "/api/v2/lookup/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		msisdn := strings.TrimPrefix(r.URL.Path, "/api/v2/lookup/")
+		if msisdn == "" || strings.Contains(msisdn, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		if b.config.Lookup.Url == "" {
+			http.Error(w, "No lookup service configured", http.StatusNotImplemented)
+			return
+		}
+		res, err := b.lookupNumber(r.Context(), msisdn)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Query string `json:"query"`
+			*lookupResult
+		}{msisdn, res})
+	})
+}
@@ -0,0 +1,17 @@
+package bridge
+
+import "strings"
+
+// mask hides the middle of a phone number in privacy mode, so
+// "+491701234589" becomes "+4917•••••89". Alphanumeric addresses and
+// short codes are left alone.
+func (b *Bridge) mask(number string) string {
+	if !b.config.Masknumbers {
+		return number
+	}
+	digits := strings.TrimPrefix(number, "+")
+	if len(digits) <= 6 || strings.Trim(digits, "0123456789") != "" {
+		return number
+	}
+	return number[:len(number)-len(digits)] + digits[:4] + strings.Repeat("•", len(digits)-6) + digits[len(digits)-2:]
+}
@@ -0,0 +1,148 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"telegram-smpp-bot/telegramsink"
+)
+
+// Moderation screens inbound SMS before they are forwarded. Flagged ones
+// go to the quarantine event destination with a Release button instead.
+type Moderation struct {
+	Url      string            // Gets {"src","dst","text"} POSTed and answers {"flagged":true,"reason":"..."}. Off if empty.
+	Headers  map[string]string // Sent along, for credentials.
+	Keywords []string          // Regular expressions that flag a text, case-insensitive.
+	Timeout  Duration          // Past this the SMS is forwarded unchecked.
+}
+
+// SMS forwarded unchecked because the moderation service failed.
+var moderationErrors = expvar.NewInt("moderation_errors")
+
+func (b *Bridge) initModeration() error {
+	for _, k := range b.config.Moderation.Keywords {
+		re, err := regexp.Compile("(?i)" + k)
+		if err != nil {
+			return fmt.Errorf("bad moderation keyword %q: %w", k, err)
+		}
+		b.keywordRes = append(b.keywordRes, re)
+	}
+	return nil
+}
+
+// moderate returns why m should not be forwarded, or "" if it may be.
+// Keywords are checked first; a failing moderation service lets the SMS
+// through, counted in moderation_errors and alerted.
+func (b *Bridge) moderate(ctx context.Context, m *Message) string {
+	for _, re := range b.keywordRes {
+		if re.MatchString(m.Text) {
+			return "keyword " + re.String()[len("(?i)"):]
+		}
+	}
+	if b.config.Moderation.Url == "" {
+		return ""
+	}
+	flagged, reason, err := b.askModerator(ctx, m)
+	if err != nil {
+		moderationErrors.Add(1)
+		log.Printf("Can't moderate SMS from %s, forwarding it. Error: %s", b.mask(m.Src), err)
+		b.alert("moderation", "Moderation service failed, forwarding SMS unchecked: "+err.Error())
+		return ""
+	}
+	if !flagged {
+		return ""
+	}
+	if reason == "" {
+		reason = "flagged"
+	}
+	return reason
+}
+
+func (b *Bridge) askModerator(ctx context.Context, m *Message) (bool, string, error) {
+	body, err := json.Marshal(map[string]string{"src": m.Src, "dst": m.Dst, "text": m.Text})
+	if err != nil {
+		return false, "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, b.config.Moderation.Timeout.Duration)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.config.Moderation.Url, bytes.NewReader(body))
+	if err != nil {
+		return false, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range b.config.Moderation.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := b.serviceClient.Do(req)
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return false, "", fmt.Errorf("moderation service: %s", resp.Status)
+	}
+	var res struct {
+		Flagged bool
+		Reason  string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return false, "", fmt.Errorf("moderation service: %w", err)
+	}
+	return res.Flagged, res.Reason, nil
+}
+
+// quarantine posts the stored inbound SMS m to the quarantine destination
+// with a Release button for admins.
+func (b *Bridge) quarantine(ctx context.Context, m *Message, reason string) {
+	defer recoverPanic("telegram sender")
+
+	text := fmt.Sprintf("🚫 Quarantined SMS #%d from %s to %s (%s):\n%s",
+		m.ID, html.EscapeString(b.mask(m.Src)), html.EscapeString(b.mask(m.Dst)), html.EscapeString(reason), html.EscapeString(m.Text))
+	markup := telegramsink.InlineKeyboard{InlineKeyboard: [][]telegramsink.InlineButton{{{Text: "✅ Release", CallbackData: "release:" + strconv.FormatInt(m.ID, 10)}}}}
+	if _, err := b.send(ctx, b.destination(eventQuarantine), text, markup); err != nil {
+		log.Printf("Can't send quarantined message %d to Telegram. Error: %s", m.ID, err)
+		errsTotal.Add(1)
+		b.alert(errorClass(err), fmt.Sprintf("Can't post quarantined SMS #%d: %s", m.ID, err))
+	}
+}
+
+// release forwards a quarantined SMS to where it would have gone and
+// returns the outcome for the user. On success the Release button is
+// removed from msg.
+func (b *Bridge) release(ctx context.Context, arg string, msg *telegramsink.Message) string {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return "Bad message id"
+	}
+	orig, ok := b.store.Get(id)
+	if !ok {
+		return fmt.Sprintf("Message #%d is not in the store", id)
+	}
+	if orig.Status != statusQuarantined {
+		return fmt.Sprintf("Message #%d is not in quarantine", id)
+	}
+	b.translateSMS(ctx, orig)
+	sent := b.sendEvent(ctx, eventSMS, b.smsText(orig))
+	if sent == nil {
+		return "Release failed, see the ops chat"
+	}
+	if _, err := b.store.Update(id, func(m *Message) {
+		m.Status = statusReleased
+		m.Lang, m.Translation = orig.Lang, orig.Translation
+		m.TgChat, m.TgMessage = sent.Chat.ID, sent.MessageID
+	}); err != nil {
+		log.Printf("Can't update message %d. Error: %s", id, err)
+	}
+	b.removeKeyboard(ctx, msg)
+	return fmt.Sprintf("Released #%d", id)
+}
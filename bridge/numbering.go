@@ -0,0 +1,102 @@
+package bridge
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"expvar"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//go:embed numbering.csv
+var builtinNumbering string
+
+// network is where a number belongs by the numbering plan.
+type network struct {
+	mcc, mnc, country, operator string
+}
+
+// Traffic by network, as "mcc-mnc" or "mcc" when the operator is unknown,
+// exported on /debug/vars. Receipts are counted by network and state.
+var (
+	smsInByNetwork  = expvar.NewMap("sms_in_by_network")
+	smsOutByNetwork = expvar.NewMap("sms_out_by_network")
+	dlrByNetwork    = expvar.NewMap("dlr_by_network")
+)
+
+// initNumbering loads the built-in numbering plan and the rows of
+// config.Numberingplan over it.
+func (b *Bridge) initNumbering() error {
+	b.numbering = make(map[string]network)
+	if err := b.readNumbering(strings.NewReader(builtinNumbering)); err != nil {
+		return fmt.Errorf("built-in numbering plan: %w", err)
+	}
+	if b.config.Numberingplan == "" {
+		return nil
+	}
+	f, err := os.Open(b.config.Numberingplan)
+	if err == nil {
+		err = b.readNumbering(f)
+		f.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("can't read numbering plan: %w", err)
+	}
+	return nil
+}
+
+func (b *Bridge) readNumbering(r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 5
+	cr.TrimLeadingSpace = true
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rec[0] == "prefix" {
+			continue
+		}
+		b.numbering[strings.TrimPrefix(rec[0], "+")] = network{rec[1], rec[2], rec[3], rec[4]}
+	}
+}
+
+// networkOf returns the network of the longest prefix of number in the
+// numbering plan.
+func (b *Bridge) networkOf(number string) (network, bool) {
+	number = strings.TrimPrefix(strings.TrimPrefix(number, "+"), "00")
+	for i := len(number); i > 0; i-- {
+		if n, ok := b.numbering[number[:i]]; ok {
+			return n, true
+		}
+	}
+	return network{}, false
+}
+
+// tagNetwork fills in the network of number on m unless a lookup already
+// did.
+func (b *Bridge) tagNetwork(m *Message, number string) {
+	if m.Mcc != "" {
+		return
+	}
+	if n, ok := b.networkOf(number); ok {
+		m.Mcc, m.Mnc, m.Country = n.mcc, n.mnc, n.country
+	}
+}
+
+// networkKey is the metrics label of the network of m.
+func networkKey(m *Message) string {
+	switch {
+	case m.Mcc == "":
+		return "unknown"
+	case m.Mnc == "":
+		return m.Mcc
+	}
+	return fmt.Sprintf("%s-%s", m.Mcc, m.Mnc)
+}
@@ -0,0 +1,262 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/fiorix/go-smpp/smpp"
+	"github.com/fiorix/go-smpp/smpp/pdu"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdufield"
+
+	"telegram-smpp-bot/smppclient"
+	"telegram-smpp-bot/telegramsink"
+)
+
+// sendSMS submits the outbound SMS m, of which the caller fills in the
+// addresses, the text and optionally UUID, RetryOf and Tenant, and records
+// it in the store under its UUID, a new one if unset. Messages that don't
+// reach the SMSC, for saturation, connection errors, cancellation or ctx
+// ending, are stored as rejected and the error returned, so the caller can
+// try again later with a new message. Messages the SMSC rejects, or didn't
+// confirm before ctx ended, are stored as failed and posted with a Retry
+// button.
+func (b *Bridge) sendSMS(ctx context.Context, m *Message) error {
+	if !b.isLeader() {
+		return errNotLeader
+	}
+	if m.UUID == "" {
+		m.UUID = newUUID()
+	}
+	m.Direction = dirOut
+	ids, h, err := b.route(ctx, m)
+	if _, busy := isBusy(err); busy || err == smpp.ErrNotConnected || err == errCancelled || err != nil && err == ctx.Err() || h == nil {
+		b.reject(m, err)
+		return err
+	}
+	// The SMSC has it now; recording and reporting it must not be cut
+	// short by the caller going away.
+	ctx = context.WithoutCancel(ctx)
+	m.Smsc = h.smsc.Name
+	m.Route = h.prefix
+	m.Price = b.priceOf(m.Dst, *h)
+	if err != nil {
+		errsTotal.Add(1)
+		log.Printf("SMSC rejected message %s to %s. Error: %s", m.UUID, b.mask(m.Dst), err)
+		b.alert("submit", "SMSC rejected submit: "+err.Error())
+		if !isPermanent(err) && !errors.Is(err, smppclient.ErrUnconfirmed) {
+			b.reject(m, err)
+			return err
+		}
+		m.Status = statusFailed
+		m.Error = err.Error()
+		if err := b.store.Add(m); err != nil {
+			log.Printf("Can't store message %s to %s. Error: %s", m.UUID, b.mask(m.Dst), err)
+		}
+		b.notifyFailure(ctx, m)
+		return err
+	}
+	smsOut.Add(1)
+	smsOutByNetwork.Add(networkKey(m), 1)
+	m.Status = statusSubmitted
+	m.Cost = m.Price * float64(m.Parts)
+	if len(ids) > 0 {
+		m.SMSCID = ids[0]
+		m.PartIDs = ids[1:]
+	}
+	log.Printf("Submitted message %s to %s as %s", m.UUID, b.mask(m.Dst), m.SMSCID)
+	if err := b.store.Add(m); err != nil {
+		log.Printf("Can't store message %s to %s. Error: %s", m.UUID, b.mask(m.Dst), err)
+	}
+	b.addSpend(m)
+	return nil
+}
+
+// route picks the SMSC for m and submits it there. It returns a nil hop
+// if m didn't get as far as an SMSC.
+func (b *Bridge) route(ctx context.Context, m *Message) ([]string, *hop, error) {
+	prefer, err := b.checkNumber(ctx, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	h, ok := b.pickRoute(m.Dst, prefer)
+	if !ok {
+		return nil, nil, fmt.Errorf("no route to %s", b.mask(m.Dst))
+	}
+	tx := h.smsc.current()
+	if tx == nil {
+		return nil, nil, smpp.ErrNotConnected
+	}
+	codec, enc, parts := smppclient.Encoding(m.Text)
+	m.Parts = parts
+	m.Encoding = enc
+	b.tagNetwork(m, m.Dst)
+	release, err := b.reserveQuota(m.Tenant, parts)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids, err := b.submit(ctx, m, tx, &smpp.ShortMessage{
+		Src:      m.Src,
+		Dst:      m.Dst,
+		Text:     codec,
+		Register: pdufield.FinalDeliveryReceipt,
+	}, parts, b.limitersFor(m.Dst, h.smsc.Name))
+	if err != nil {
+		release()
+	}
+	return ids, &h, err
+}
+
+// reject stores m as a message that didn't reach the SMSC for err, so that
+// its UUID can still be looked up.
+func (b *Bridge) reject(m *Message, err error) {
+	m.Status = statusRejected
+	if err == errCancelled {
+		m.Status = statusCancelled
+	}
+	m.Error = err.Error()
+	if err := b.store.Add(m); err != nil {
+		log.Printf("Can't store message %s to %s. Error: %s", m.UUID, b.mask(m.Dst), err)
+	}
+}
+
+// isPermanent reports whether err is a submit_sm_resp error status, as
+// opposed to a connection problem.
+func isPermanent(err error) bool {
+	var status pdu.Status
+	return errors.As(err, &status)
+}
+
+// notifyFailure posts a failed outbound message to the receipts
+// destination, with a Retry button if there are admins to press it, and
+// links the stored message to the post.
+func (b *Bridge) notifyFailure(ctx context.Context, m *Message) {
+	defer recoverPanic("telegram sender")
+
+	text := fmt.Sprintf("❌ SMS #%d from %s to %s failed: %s\n%s", m.ID, html.EscapeString(b.mask(m.Src)), html.EscapeString(b.mask(m.Dst)), html.EscapeString(m.Error), html.EscapeString(m.Text))
+	var markup interface{}
+	if len(b.config.Admins) > 0 {
+		markup = telegramsink.InlineKeyboard{InlineKeyboard: [][]telegramsink.InlineButton{{{Text: "🔁 Retry", CallbackData: "retry:" + strconv.FormatInt(m.ID, 10)}}}}
+	}
+	sent, err := b.send(ctx, b.destination(eventDLR), text, markup)
+	if err != nil {
+		log.Printf("Can't send failure of message %d to Telegram. Error: %s", m.ID, err)
+		errsTotal.Add(1)
+		return
+	}
+	if _, err := b.store.Update(m.ID, func(s *Message) { s.TgChat, s.TgMessage = sent.Chat.ID, sent.MessageID }); err != nil {
+		log.Printf("Can't update message %s. Error: %s", m.UUID, err)
+	}
+}
+
+// Receipt states meaning the message won't be delivered.
+var failedStates = map[string]bool{"UNDELIV": true, "REJECTD": true, "EXPIRED": true, "DELETED": true}
+
+// handleReceipt matches a delivery receipt to the stored outbound message,
+// updates its status and posts the receipt, as a failure with a Retry
+// button if the message was not delivered. Only the callback gets it while
+// forwarding is paused.
+func (b *Bridge) handleReceipt(ctx context.Context, src, dst, text string) {
+	id, state := smppclient.ParseReceipt(text)
+	if orig, ok := b.lookupSMSCID(id); ok && state != "" {
+		m, err := b.store.Update(orig.ID, func(m *Message) {
+			m.Status = state
+			if failedStates[state] {
+				m.Error = "delivery receipt " + state
+			}
+		})
+		if err != nil {
+			log.Printf("Can't update message %d. Error: %s", orig.ID, err)
+		} else {
+			dlrByNetwork.Add(networkKey(m)+"/"+state, 1)
+			b.postCallback(callbackEvent{Event: eventDLR, Message: m, Receipt: text})
+			if failedStates[state] && !b.forwardingPaused.Load() {
+				b.notifyFailure(ctx, m)
+				return
+			}
+		}
+	} else {
+		b.postCallback(callbackEvent{Event: eventDLR, Receipt: text})
+	}
+	if b.forwardingPaused.Load() {
+		return
+	}
+	b.sendEvent(ctx, eventDLR, "Delivery receipt from "+b.mask(src)+" to "+b.mask(dst)+" :\n"+text)
+}
+
+// lookupSMSCID finds an outbound message by the id in a receipt. Some
+// SMSCs return hex ids in submit_sm_resp and decimal ones in receipts, so
+// both forms are tried.
+func (b *Bridge) lookupSMSCID(id string) (*Message, bool) {
+	if id == "" {
+		return nil, false
+	}
+	if m, ok := b.store.BySMSCID(id); ok {
+		return m, true
+	}
+	if n, err := strconv.ParseUint(id, 10, 64); err == nil {
+		if m, ok := b.store.BySMSCID(strconv.FormatUint(n, 16)); ok {
+			return m, true
+		}
+		if m, ok := b.store.BySMSCID(strings.ToUpper(strconv.FormatUint(n, 16))); ok {
+			return m, true
+		}
+	}
+	if n, err := strconv.ParseUint(id, 16, 64); err == nil {
+		return b.store.BySMSCID(strconv.FormatUint(n, 10))
+	}
+	return nil, false
+}
+
+// forwardSMS posts an inbound SMS to Telegram and stores it together with
+// the Telegram message, so it can be answered with /reply.
+func (b *Bridge) forwardSMS(ctx context.Context, src, dst, text string) {
+	smsIn.Add(1)
+	m := &Message{UUID: newUUID(), Direction: dirIn, Src: src, Dst: dst, Text: text}
+	b.tagNetwork(m, src)
+	smsInByNetwork.Add(networkKey(m), 1)
+	if reason := b.moderate(ctx, m); reason != "" {
+		log.Printf("Quarantining SMS %s from %s: %s", m.UUID, b.mask(src), reason)
+		m.Status = statusQuarantined
+		m.Error = reason
+		if err := b.store.Add(m); err != nil {
+			log.Printf("Can't store message from %s. Error: %s", b.mask(src), err)
+		}
+		b.quarantine(ctx, m, reason)
+		b.postCallback(callbackEvent{Event: eventSMS, Message: m})
+		return
+	}
+	if b.forwardingPaused.Load() {
+		// Kept for /replay once forwarding resumes.
+		log.Printf("Forwarding is paused, only storing SMS %s from %s", m.UUID, b.mask(src))
+		if err := b.store.Add(m); err != nil {
+			log.Printf("Can't store message from %s. Error: %s", b.mask(src), err)
+		}
+		b.postCallback(callbackEvent{Event: eventSMS, Message: m})
+		return
+	}
+	b.translateSMS(ctx, m)
+	if sent := b.sendEvent(ctx, eventSMS, b.smsText(m)); sent != nil {
+		m.TgChat = sent.Chat.ID
+		m.TgMessage = sent.MessageID
+		log.Printf("Forwarded SMS %s from %s as Telegram message %d", m.UUID, b.mask(src), m.TgMessage)
+	}
+	if err := b.store.Add(m); err != nil {
+		log.Printf("Can't store message from %s. Error: %s", b.mask(src), err)
+	}
+	b.postCallback(callbackEvent{Event: eventSMS, Message: m})
+}
+
+// smsText renders an inbound SMS for the chat, with its translation
+// below if it has one.
+func (b *Bridge) smsText(m *Message) string {
+	text := "SMS from " + b.mask(m.Src) + " to " + b.mask(m.Dst) + " :\n" + m.Text
+	if m.Translation != "" {
+		text += "\n\n🌐 " + html.EscapeString(m.Lang) + " → " + html.EscapeString(b.config.Translation.Target) + ":\n" + html.EscapeString(m.Translation)
+	}
+	return text
+}
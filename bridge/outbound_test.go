@@ -0,0 +1,98 @@
+package bridge
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"telegram-smpp-bot/telegramsink"
+)
+
+func TestReceipts(t *testing.T) {
+	tests := []struct {
+		name   string
+		state  string
+		post   string // Start of the text posted to Telegram.
+		retry  bool   // Whether the post has a Retry button.
+		failed bool
+	}{
+		{"delivered", "DELIVRD", "Delivery receipt from", false, false},
+		{"undeliverable", "UNDELIV", "❌ SMS #", true, true},
+		{"expired", "EXPIRED", "❌ SMS #", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := startBridge(t, &Config{})
+			m := tb.submit(t, "+4915112345678", "hi")
+			before := len(tb.sent())
+
+			tb.smsc.Receipt("+4915112345678", "TEST", m.SMSCID, tt.state)
+			texts := tb.waitForSent(t, before+1)
+			if !strings.HasPrefix(texts[before], tt.post) {
+				t.Errorf("Got post %q, want it to start with %q", texts[before], tt.post)
+			}
+			calls := tb.tg.Calls("sendMessage")
+			markup := calls[before].Form["reply_markup"]
+			if got := strings.Contains(markup, "retry:"+strconv.FormatInt(m.ID, 10)); got != tt.retry {
+				t.Errorf("Got markup %q, want a Retry button: %v", markup, tt.retry)
+			}
+
+			m, _ = tb.store.Get(m.ID)
+			if m.Status != tt.state || (m.Error != "") != tt.failed {
+				t.Errorf("Got status %q with error %q, want %q", m.Status, m.Error, tt.state)
+			}
+			if tt.failed {
+				waitFor(t, "the post to be linked", func() bool {
+					m, _ = tb.store.Get(m.ID)
+					return m.TgMessage != 0
+				})
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    int64
+		presses int
+		answers []string
+		submits int // To the SMSC, including the original.
+	}{
+		{"once", testAdmin, 1, []string{"Resubmitted as #"}, 2},
+		{"twice", testAdmin, 2, []string{"Resubmitted as #", "Message #1: already retried"}, 2},
+		{"not an admin", testAdmin + 1, 1, []string{"Only admins can retry messages"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := startBridge(t, &Config{})
+			m := tb.submit(t, "+4915112345678", "hi")
+			tb.smsc.Receipt("+4915112345678", "TEST", m.SMSCID, "UNDELIV")
+			waitFor(t, "the failure post", func() bool {
+				m, _ = tb.store.Get(m.ID)
+				return m.TgMessage != 0
+			})
+
+			post := &telegramsink.Message{MessageID: m.TgMessage, Chat: telegramsink.Chat{ID: m.TgChat}}
+			for i := 0; i < tt.presses; i++ {
+				tb.tg.Push(telegramsink.Update{CallbackQuery: &telegramsink.CallbackQuery{
+					ID:      strconv.Itoa(i),
+					From:    telegramsink.User{ID: tt.from},
+					Message: post,
+					Data:    "retry:" + strconv.FormatInt(m.ID, 10),
+				}})
+				waitFor(t, "the answer", func() bool { return len(tb.tg.Calls("answerCallbackQuery")) > i })
+			}
+
+			answers := tb.tg.Calls("answerCallbackQuery")
+			for i, want := range tt.answers {
+				if !strings.HasPrefix(answers[i].Form["text"], want) {
+					t.Errorf("Got answer %q to press %d, want %q", answers[i].Form["text"], i+1, want)
+				}
+			}
+			if n := len(tb.smsc.Submitted()); n != tt.submits {
+				t.Errorf("Got %d submits, want %d", n, tt.submits)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"telegram-smpp-bot/api"
+)
+
+// mayManageSubjects reports whether the caller may export and delete the
+// data of any number: only tenants with Privacy set.
+func (b *Bridge) mayManageSubjects(r *http.Request) bool {
+	id := identityOf(r)
+	return id != nil && b.config.Tenants[id.Tenant].Privacy
+}
+
+func (b *Bridge) registerPrivacy() {
+	// GET /api/v2/subjects/{msisdn} exports every stored message from or
+	// to a number; DELETE erases them, along with their forwards in
+	// Telegram where the bot can still delete those.
+	b.handle(api.GroupAPI, "/api/v2/subjects/", func(w http.ResponseWriter, r *http.Request) {
+		msisdn := strings.TrimPrefix(r.URL.Path, "/api/v2/subjects/")
+		if msisdn == "" || strings.Contains(msisdn, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		if !b.mayManageSubjects(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		ms := b.store.Involving(msisdn)
+		switch r.Method {
+		case http.MethodGet:
+			b.audit(r, "export", msisdn, len(ms), nil)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="subject-%s.json"`, strings.TrimPrefix(msisdn, "+")))
+			json.NewEncoder(w).Encode(struct {
+				Subject  string    `json:"subject"`
+				Exported time.Time `json:"exported"`
+				Messages []Message `json:"messages"`
+			}{msisdn, time.Now(), ms})
+		case http.MethodDelete:
+			ids := make([]int64, len(ms))
+			for i, m := range ms {
+				ids[i] = m.ID
+			}
+			err := b.store.Delete(ids)
+			b.audit(r, "delete", msisdn, len(ms), err)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			go b.deleteForwards(b.runCtx, ms)
+			w.Write([]byte(strconv.Itoa(len(ms))))
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// deleteForwards removes the Telegram messages that forwarded ms. Telegram
+// only lets bots delete recent messages, so failures are just logged.
+func (b *Bridge) deleteForwards(ctx context.Context, ms []Message) {
+	defer recoverPanic("telegram sender")
+
+	for _, m := range ms {
+		if m.TgMessage == 0 {
+			continue
+		}
+		err := b.call(ctx, "deleteMessage", map[string]string{
+			"chat_id":    strconv.FormatInt(m.TgChat, 10),
+			"message_id": strconv.FormatInt(m.TgMessage, 10),
+		}, nil)
+		if err != nil {
+			log.Printf("Can't delete Telegram message %d of message %d. Error: %s", m.TgMessage, m.ID, err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package bridge
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+)
+
+// Quota is a budget of outbound messages or parts per day or month, for
+// one API identity or for all traffic.
+type Quota struct {
+	Tenant   string // API identity, all traffic if empty.
+	Period   string // "daily" or "monthly".
+	Messages int    // Max messages per period, unlimited if 0.
+	Parts    int    // Max parts per period, unlimited if 0.
+	Alerts   []int  // Usage in percent that is reported to the ops chat, 80 and 100 if empty.
+	Hardstop bool   // Reject submits once the budget is used up.
+}
+
+// quotaError is returned by sendSMS when a hard quota is used up.
+type quotaError struct {
+	q *quotaState
+}
+
+func (e *quotaError) Error() string {
+	return fmt.Sprintf("%s quota exhausted", e.q.name())
+}
+
+// quotaState is the usage of a quota in the current period.
+type quotaState struct {
+	Quota
+	period   string       // Key of the current period, like "2024-05".
+	messages int          // Used in the period.
+	parts    int          // Used in the period.
+	alerted  map[int]bool // Thresholds reported in the period.
+}
+
+// periodOf returns the key and the start of the quota period holding t.
+func periodOf(period string, t time.Time) (string, time.Time) {
+	if period == "monthly" {
+		return t.Format("2006-01"), time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	}
+	return t.Format("2006-01-02"), time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// initQuotas sets up the configured quotas with the usage of the current
+// period so far from the store.
+func (b *Bridge) initQuotas() error {
+	now := time.Now()
+	for _, q := range b.config.Quotas {
+		switch q.Period {
+		case "daily", "monthly":
+		default:
+			return fmt.Errorf("unknown quota period %q", q.Period)
+		}
+		if len(q.Alerts) == 0 {
+			q.Alerts = []int{80, 100}
+		}
+		s := &quotaState{Quota: q, alerted: make(map[int]bool)}
+		var start time.Time
+		s.period, start = periodOf(q.Period, now)
+		for _, m := range b.store.Between(start, now) {
+			if s.covers(&m) && m.Status != statusFailed && !neverSent(&m) {
+				s.messages++
+				s.parts += m.Parts
+			}
+		}
+		// Thresholds passed before a restart were reported already.
+		for _, a := range q.Alerts {
+			if s.percent() >= a {
+				s.alerted[a] = true
+			}
+		}
+		b.quotas = append(b.quotas, s)
+	}
+	return nil
+}
+
+func (s *quotaState) name() string {
+	if s.Tenant == "" {
+		return s.Period
+	}
+	return s.Period + " " + s.Tenant
+}
+
+func (s *quotaState) covers(m *Message) bool {
+	return m.Direction == dirOut && (s.Tenant == "" || s.Tenant == m.Tenant)
+}
+
+// percent returns the usage of the fuller of both budgets.
+func (s *quotaState) percent() int {
+	p := 0
+	if s.Messages > 0 {
+		p = 100 * s.messages / s.Messages
+	}
+	if s.Parts > 0 && 100*s.parts/s.Parts > p {
+		p = 100 * s.parts / s.Parts
+	}
+	return p
+}
+
+// reserveQuota counts a message of the given parts against every quota
+// covering tenant. It fails without counting if a hard quota would be
+// exceeded. The returned function takes the message back if it was not
+// sent after all.
+func (b *Bridge) reserveQuota(tenant string, parts int) (release func(), err error) {
+	b.quotaMu.Lock()
+	defer b.quotaMu.Unlock()
+	now := time.Now()
+	m := &Message{Direction: dirOut, Tenant: tenant}
+	var held []*quotaState
+	var periods []string
+	for _, s := range b.quotas {
+		if !s.covers(m) {
+			continue
+		}
+		if p, _ := periodOf(s.Period, now); p != s.period {
+			s.period, s.messages, s.parts, s.alerted = p, 0, 0, make(map[int]bool)
+		}
+		if s.Hardstop && (s.Messages > 0 && s.messages+1 > s.Messages || s.Parts > 0 && s.parts+parts > s.Parts) {
+			return nil, &quotaError{s}
+		}
+		held = append(held, s)
+		periods = append(periods, s.period)
+	}
+	for _, s := range held {
+		s.messages++
+		s.parts += parts
+		for _, a := range s.Alerts {
+			if s.percent() >= a && !s.alerted[a] {
+				s.alerted[a] = true
+				go b.sendOps(fmt.Sprintf("📈 %s quota at %d%%: %s", html.EscapeString(s.name()), a, s.usage()))
+			}
+		}
+	}
+	return func() {
+		b.quotaMu.Lock()
+		defer b.quotaMu.Unlock()
+		for i, s := range held {
+			if s.period == periods[i] {
+				s.messages--
+				s.parts -= parts
+			}
+		}
+	}, nil
+}
+
+func (s *quotaState) usage() string {
+	var u []string
+	if s.Messages > 0 {
+		u = append(u, fmt.Sprintf("%d/%d messages", s.messages, s.Messages))
+	}
+	if s.Parts > 0 {
+		u = append(u, fmt.Sprintf("%d/%d parts", s.parts, s.Parts))
+	}
+	return strings.Join(u, ", ")
+}
@@ -0,0 +1,68 @@
+package bridge
+
+import (
+	"expvar"
+	"fmt"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// Ratelimit is a token bucket for the submits to a destination prefix,
+// through an SMSC, or both. It applies on top of the global limit.
+type Ratelimit struct {
+	Name   string  // Scope name in metrics and errors, made up from Prefix and Smsc if empty.
+	Prefix string  // Destination prefix like "+49" or "1" for shortcodes, any if empty.
+	Smsc   string  // SMSC name, any if empty.
+	Rate   float64 // Submits per second.
+	Burst  int
+}
+
+// Submits rejected by a rate limit, by scope name; "global" is the
+// overall limit.
+var throttledByScope = expvar.NewMap("throttled_by_scope")
+
+type scopedLimiter struct {
+	Ratelimit
+	*rate.Limiter
+}
+
+func (b *Bridge) initRatelimits() error {
+	for _, r := range b.config.Ratelimits {
+		if r.Rate <= 0 {
+			return fmt.Errorf("rate limit %+v needs a rate", r)
+		}
+		if r.Smsc != "" && b.smscNamed[r.Smsc] == nil {
+			return fmt.Errorf("rate limit uses unknown SMSC %q", r.Smsc)
+		}
+		r.Prefix = strings.TrimPrefix(r.Prefix, "+")
+		if r.Name == "" {
+			var scope []string
+			if r.Prefix != "" {
+				scope = append(scope, "+"+r.Prefix)
+			}
+			if r.Smsc != "" {
+				scope = append(scope, r.Smsc)
+			}
+			r.Name = strings.Join(scope, " via ")
+		}
+		if r.Burst < 1 {
+			r.Burst = 1
+		}
+		b.scopedLimiters = append(b.scopedLimiters, &scopedLimiter{r, rate.NewLimiter(rate.Limit(r.Rate), r.Burst)})
+	}
+	return nil
+}
+
+// limitersFor returns the scoped limiters that apply to a submit to dst
+// through smsc.
+func (b *Bridge) limitersFor(dst, smsc string) []*scopedLimiter {
+	dst = strings.TrimPrefix(dst, "+")
+	var ls []*scopedLimiter
+	for _, l := range b.scopedLimiters {
+		if strings.HasPrefix(dst, l.Prefix) && (l.Smsc == "" || l.Smsc == smsc) {
+			ls = append(ls, l)
+		}
+	}
+	return ls
+}
@@ -0,0 +1,163 @@
+package bridge
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// redisClient is a minimal RESP client for the few Redis commands the
+// bridge needs. It keeps one connection and redials after errors.
+type redisClient struct {
+	addr     string
+	password string
+	db       int
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Redis is a Redis server.
+type Redis struct {
+	Address  string // host:port
+	Password string
+	Db       int
+}
+
+func newRedisClient(c Redis) *redisClient {
+	return &redisClient{addr: c.Address, password: c.Password, db: c.Db}
+}
+
+// redisNil is returned for nil replies.
+var redisNil = errors.New("redis: nil")
+
+// redisTimeout bounds every command, unless ctx ends it earlier.
+const redisTimeout = 5 * time.Second
+
+// do runs a command and returns its reply: a string, an int64 or a slice
+// of replies. When ctx is done the connection is cut off and redialed by
+// the next command.
+func (c *redisClient) do(ctx context.Context, args ...string) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		if err := c.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+	conn := c.conn
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	v, err := c.roundTrip(args)
+	if !stop() && err != nil {
+		err = ctx.Err()
+	}
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) && err != redisNil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	return v, err
+}
+
+func (c *redisClient) dial(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	c.conn, c.r = conn, bufio.NewReader(conn)
+	if c.password != "" {
+		if _, err := c.roundTrip([]string{"AUTH", c.password}); err != nil {
+			conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.roundTrip([]string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *redisClient) roundTrip(args []string) (interface{}, error) {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(a))+"\r\n"...)
+		buf = append(buf, a...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func (c *redisClient) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, fmt.Errorf("redis: short reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, redisNil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, redisNil
+		}
+		vs := make([]interface{}, n)
+		for i := range vs {
+			vs[i], err = c.read()
+			if err != nil && err != redisNil {
+				return nil, err
+			}
+		}
+		return vs, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -0,0 +1,181 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"telegram-smpp-bot/api"
+	"telegram-smpp-bot/telegramsink"
+)
+
+// isAdmin reports whether the caller is a tenant with Admin set.
+func (b *Bridge) isAdmin(r *http.Request) bool {
+	id := identityOf(r)
+	return id != nil && b.config.Tenants[id.Tenant].Admin
+}
+
+// replaySelection returns the stored messages named by refs, UUIDs or
+// IDs, or stored in [from, to) if there are none, and the refs that name
+// no stored message.
+func (b *Bridge) replaySelection(refs []string, from, to time.Time) (ms []Message, unknown []string) {
+	if len(refs) == 0 {
+		return b.store.Between(from, to), nil
+	}
+	for _, ref := range refs {
+		if m, ok := b.store.Lookup(ref); ok {
+			ms = append(ms, *m)
+		} else {
+			unknown = append(unknown, ref)
+		}
+	}
+	return ms, unknown
+}
+
+// replay forwards the inbound SMS among ms to Telegram again, the way they
+// would go now, and links the stored messages to the new forwards.
+// Outbound and quarantined messages are skipped, and the others moderated
+// again unless an admin released them; it stops if forwarding is paused.
+// The callback is not posted again, forwardSMS did when they came in. It
+// returns how many were forwarded, quarantined and failed.
+func (b *Bridge) replay(ctx context.Context, ms []Message) (replayed, quarantined, failed int) {
+	for i := range ms {
+		m := &ms[i]
+		if m.Direction != dirIn || m.Status == statusQuarantined {
+			continue
+		}
+		if ctx.Err() != nil || b.forwardingPaused.Load() {
+			break
+		}
+		if m.Status != statusReleased {
+			if reason := b.moderate(ctx, m); reason != "" {
+				log.Printf("Quarantining replayed SMS %s from %s: %s", m.UUID, b.mask(m.Src), reason)
+				if _, err := b.store.Update(m.ID, func(s *Message) { s.Status, s.Error = statusQuarantined, reason }); err != nil {
+					log.Printf("Can't update message %s. Error: %s", m.UUID, err)
+				}
+				b.quarantine(ctx, m, reason)
+				quarantined++
+				continue
+			}
+		}
+		b.translateSMS(ctx, m)
+		sent := b.sendEvent(ctx, eventSMS, b.smsText(m))
+		if sent == nil {
+			failed++
+			continue
+		}
+		replayed++
+		if _, err := b.store.Update(m.ID, func(s *Message) {
+			s.TgChat, s.TgMessage = sent.Chat.ID, sent.MessageID
+			s.Lang, s.Translation = m.Lang, m.Translation
+		}); err != nil {
+			log.Printf("Can't update message %s. Error: %s", m.UUID, err)
+		}
+	}
+	return replayed, quarantined, failed
+}
+
+// splitRefs reads message UUIDs or IDs separated by commas or spaces.
+func splitRefs(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+func (b *Bridge) registerReplay() {
+	// POST /api/v2/messages/replay with ids=1,2,3 (UUIDs or store IDs)
+	// or from=...&to=...
+	// forwards those stored inbound SMS to Telegram again. Admin tenants
+	// only.
+	b.handle(api.GroupAPI, "/api/v2/messages/replay", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !b.isAdmin(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if b.forwardingPaused.Load() {
+			http.Error(w, "Forwarding is paused", http.StatusConflict)
+			return
+		}
+		ids := splitRefs(r.FormValue("ids"))
+		from, to := time.Time{}, time.Now()
+		var err error
+		if v := r.FormValue("from"); v != "" {
+			if from, err = parseTime(v); err != nil {
+				http.Error(w, "Bad from: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else if len(ids) == 0 {
+			http.Error(w, "Want ids or from", http.StatusBadRequest)
+			return
+		}
+		if v := r.FormValue("to"); v != "" {
+			if to, err = parseTime(v); err != nil {
+				http.Error(w, "Bad to: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		subject := r.FormValue("ids")
+		if len(ids) == 0 {
+			subject = from.Format(time.RFC3339) + "/" + to.Format(time.RFC3339)
+		}
+		ms, unknown := b.replaySelection(ids, from, to)
+		if len(unknown) > 0 {
+			http.Error(w, "Unknown messages: "+strings.Join(unknown, ", "), http.StatusNotFound)
+			return
+		}
+		replayed, quarantined, failed := b.replay(r.Context(), ms)
+		b.audit(r, "replay", subject, replayed, nil)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Replayed    int `json:"replayed"`
+			Quarantined int `json:"quarantined"`
+			Failed      int `json:"failed"`
+		}{replayed, quarantined, failed})
+	})
+}
+
+// cmdReplay forwards stored inbound SMS again, given as UUIDs or IDs or
+// as a time range, in the background, and reports how it went.
+func (b *Bridge) cmdReplay(ctx context.Context, msg *telegramsink.Message, args string) {
+	refs := splitRefs(args)
+	if len(refs) == 0 {
+		b.reply(ctx, msg, "Usage: /replay id... or /replay from to, times as 2006-01-02 or RFC 3339", nil)
+		return
+	}
+	if b.forwardingPaused.Load() {
+		b.reply(ctx, msg, "Forwarding is paused, /resume it first.", nil)
+		return
+	}
+	var ms []Message
+	var unknown []string
+	if len(refs) == 2 {
+		f, err1 := parseTime(refs[0])
+		t, err2 := parseTime(refs[1])
+		if err1 == nil && err2 == nil {
+			ms, _ = b.replaySelection(nil, f, t)
+			refs = nil
+		}
+	}
+	if refs != nil {
+		ms, unknown = b.replaySelection(refs, time.Time{}, time.Time{})
+	}
+	if len(unknown) > 0 {
+		b.reply(ctx, msg, "Unknown messages: "+html.EscapeString(strings.Join(unknown, ", ")), nil)
+		return
+	}
+	log.Printf("User %d replays %d stored messages", msg.From.ID, len(ms))
+	go func() {
+		defer recoverPanic("replay")
+		replayed, quarantined, failed := b.replay(b.runCtx, ms)
+		b.reply(b.runCtx, msg, fmt.Sprintf("🔁 Replayed %d SMS, %d quarantined, %d failed.", replayed, quarantined, failed), nil)
+	}()
+	b.reply(ctx, msg, fmt.Sprintf("Replaying up to %d stored messages…", len(ms)), nil)
+}
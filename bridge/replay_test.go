@@ -0,0 +1,119 @@
+package bridge
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPauseReplay(t *testing.T) {
+	tests := []struct {
+		name   string
+		apikey string
+		ids    func(m *Message) string
+		paused bool // Replay before resuming.
+		status int
+	}{
+		{"by UUID", "admin", func(m *Message) string { return m.UUID }, false, http.StatusOK},
+		{"by upper case UUID", "admin", func(m *Message) string { return strings.ToUpper(m.UUID) }, false, http.StatusOK},
+		{"by ID", "admin", func(m *Message) string { return "#" + strconv.FormatInt(m.ID, 10) }, false, http.StatusOK},
+		{"unknown", "admin", func(m *Message) string { return m.UUID + ",#99" }, false, http.StatusNotFound},
+		{"not an admin", "user", func(m *Message) string { return m.UUID }, false, http.StatusForbidden},
+		{"while paused", "admin", func(m *Message) string { return m.UUID }, true, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := startBridge(t, &Config{Tenants: map[string]Tenant{
+				"ops":  {Admin: true, Apikeys: []string{"admin"}},
+				"user": {Apikeys: []string{"user"}},
+			}})
+			tb.apikey = "admin"
+			if resp, body := tb.do(t, http.MethodPost, "/api/v2/admin/pause", nil); resp.StatusCode != http.StatusOK {
+				t.Fatalf("pause: %s %s", resp.Status, body)
+			}
+
+			before := len(tb.sent())
+			tb.smsc.Deliver("+4915112345678", "TEST", "paused")
+			var m *Message
+			waitFor(t, "the SMS to be stored", func() bool {
+				ms := tb.store.Between(time.Time{}, time.Now().Add(time.Hour))
+				if len(ms) == 0 {
+					return false
+				}
+				m = &ms[0]
+				return true
+			})
+			if n := len(tb.sent()); n != before {
+				t.Fatalf("Got %d posts while paused, want none", n-before)
+			}
+			if m.Text != "paused" || m.TgMessage != 0 {
+				t.Errorf("Got stored %+v", m)
+			}
+			if !tt.paused {
+				if resp, body := tb.do(t, http.MethodPost, "/api/v2/admin/resume", nil); resp.StatusCode != http.StatusOK {
+					t.Fatalf("resume: %s %s", resp.Status, body)
+				}
+			}
+
+			tb.apikey = tt.apikey
+			resp, body := tb.do(t, http.MethodPost, "/api/v2/messages/replay", url.Values{"ids": {tt.ids(m)}})
+			if resp.StatusCode != tt.status {
+				t.Fatalf("Got %s %s, want %d", resp.Status, body, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				if n := len(tb.sent()); n != before {
+					t.Errorf("Got %d posts, want none", n-before)
+				}
+				return
+			}
+			if body != `{"replayed":1,"quarantined":0,"failed":0}`+"\n" {
+				t.Errorf("Got %q", body)
+			}
+			texts := tb.sent()
+			if len(texts) != before+1 || !strings.HasSuffix(texts[before], ":\npaused") {
+				t.Errorf("Got posts %q, want the replayed SMS", texts[before:])
+			}
+			if m, _ = tb.store.Get(m.ID); m.TgMessage == 0 {
+				t.Error("Replayed message is not linked to its post")
+			}
+		})
+	}
+}
+
+func TestReplayModerated(t *testing.T) {
+	var flag atomic.Bool
+	moderator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"flagged":%t,"reason":"spam"}`, flag.Load())
+	}))
+	defer moderator.Close()
+	cfg := &Config{Tenants: map[string]Tenant{"ops": {Admin: true, Apikeys: []string{"admin"}}}}
+	cfg.Moderation.Url = moderator.URL
+	tb := startBridge(t, cfg)
+	tb.apikey = "admin"
+
+	tb.smsc.Deliver("+4915112345678", "TEST", "buy now")
+	texts := tb.waitForSent(t, 1)
+	m, ok := tb.store.ByTelegram(-100, 1)
+	if !ok {
+		t.Fatalf("Got posts %q, no stored SMS", texts)
+	}
+
+	// The moderator has changed its mind since.
+	flag.Store(true)
+	resp, body := tb.do(t, http.MethodPost, "/api/v2/messages/replay", url.Values{"ids": {m.UUID}})
+	if resp.StatusCode != http.StatusOK || body != `{"replayed":0,"quarantined":1,"failed":0}`+"\n" {
+		t.Fatalf("Got %s %s", resp.Status, body)
+	}
+	if m, _ = tb.store.Get(m.ID); m.Status != statusQuarantined || m.Error != "spam" {
+		t.Errorf("Got stored %+v, want it quarantined", m)
+	}
+	if texts := tb.sent(); len(texts) != 2 || !strings.HasPrefix(texts[1], "🚫 Quarantined SMS") {
+		t.Errorf("Got posts %q, want the SMS quarantined", texts)
+	}
+}
@@ -0,0 +1,125 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dailyReport posts the traffic of the last day from the message store to
+// the report chat at config.Reporttime every day.
+func (b *Bridge) dailyReport(ctx context.Context) {
+	for {
+		next, err := nextAt(b.config.Reporttime, time.Now())
+		if err != nil {
+			log.Printf("Bad reporttime %q, no daily report. Error: %s", b.config.Reporttime, err)
+			return
+		}
+		if !sleep(ctx, time.Until(next)) {
+			return
+		}
+		if !b.isLeader() {
+			continue
+		}
+		ms := b.store.Between(next.AddDate(0, 0, -1), next)
+		if err := b.sendTo(ctx, b.config.Reportchat, b.config.Reporttopic, b.renderReport(ms, next)); err != nil {
+			log.Printf("Can't send daily report to Telegram. Error: %s", err)
+			errsTotal.Add(1)
+		}
+	}
+}
+
+// renderReport summarizes the messages of the day ending at end.
+func (b *Bridge) renderReport(ms []Message, end time.Time) string {
+	var in, out, parts, delivered, final int
+	senders := make(map[string]int)
+	failures := make(map[string]int)
+	costs := make(map[string]float64)
+	for _, m := range ms {
+		if neverSent(&m) {
+			continue
+		}
+		senders[m.Src]++
+		if m.Direction == dirIn {
+			in++
+			continue
+		}
+		out++
+		parts += m.Parts
+		if m.Cost > 0 {
+			costs[tenantKey(&m)] += m.Cost
+		}
+		switch {
+		case m.Status == "DELIVRD":
+			delivered++
+			final++
+		case m.Status == statusFailed || failedStates[m.Status]:
+			final++
+			reason := m.Error
+			if reason == "" {
+				reason = m.Status
+			}
+			failures[reason]++
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📊 <b>Daily report</b> %s – %s\n\n", end.AddDate(0, 0, -1).Format("2006-01-02 15:04"), end.Format("2006-01-02 15:04"))
+	fmt.Fprintf(&sb, "In: %d\nOut: %d (%d parts)\n", in, out, parts)
+	if final > 0 {
+		fmt.Fprintf(&sb, "Delivery rate: %.1f%% (%d of %d with a final state)\n", 100*float64(delivered)/float64(final), delivered, final)
+	}
+	if len(costs) > 0 {
+		var total float64
+		keys := make([]string, 0, len(costs))
+		for k, v := range costs {
+			total += v
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(&sb, "\n<b>Spend</b> %.2f %s\n", total, html.EscapeString(b.config.Currency))
+		for _, k := range keys {
+			fmt.Fprintf(&sb, "%s: %.2f\n", html.EscapeString(k), costs[k])
+		}
+	}
+	if len(senders) > 0 {
+		sb.WriteString("\n<b>Top senders</b>\n")
+		for _, kv := range top(senders, 5) {
+			fmt.Fprintf(&sb, "%s: %d\n", html.EscapeString(b.mask(kv.key)), kv.n)
+		}
+	}
+	if len(failures) > 0 {
+		sb.WriteString("\n<b>Errors</b>\n")
+		for _, kv := range top(failures, 10) {
+			fmt.Fprintf(&sb, "%s: %d\n", html.EscapeString(kv.key), kv.n)
+		}
+	}
+	return sb.String()
+}
+
+type count struct {
+	key string
+	n   int
+}
+
+// top returns the n keys of m with the highest counts.
+func top(m map[string]int, n int) []count {
+	cs := make([]count, 0, len(m))
+	for k, v := range m {
+		cs = append(cs, count{k, v})
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		if cs[i].n != cs[j].n {
+			return cs[i].n > cs[j].n
+		}
+		return cs[i].key < cs[j].key
+	})
+	if len(cs) > n {
+		cs = cs[:n]
+	}
+	return cs
+}
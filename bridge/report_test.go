@@ -0,0 +1,25 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportLeavesOutUnsent(t *testing.T) {
+	b := New(&Config{})
+	ms := []Message{
+		{Direction: dirIn, Src: "+491", Dst: "TEST"},
+		{Direction: dirOut, Src: "TEST", Dst: "+492", Parts: 2, Status: "DELIVRD"},
+		{Direction: dirOut, Src: "TEST", Dst: "+492", Parts: 1, Status: statusFailed, Error: "0x0000000b"},
+		{Direction: dirOut, Src: "TEST", Dst: "+492", Parts: 3, Status: statusRejected, Error: "submit queue is full"},
+		{Direction: dirOut, Src: "TEST", Dst: "+492", Parts: 1, Status: statusCancelled, Error: "cancelled"},
+	}
+	got := b.renderReport(ms, time.Now())
+	if !strings.Contains(got, "In: 1\nOut: 2 (3 parts)\n") || !strings.Contains(got, "TEST: 2\n") {
+		t.Errorf("Got report\n%s\nwant 2 messages out in 3 parts", got)
+	}
+	if strings.Contains(got, "queue is full") || strings.Contains(got, "cancelled") {
+		t.Errorf("Got report\n%s\nwith the errors of unsent messages", got)
+	}
+}
@@ -0,0 +1,45 @@
+package bridge
+
+// role is what a Telegram user may do with the bot. Each role includes the
+// ones below it.
+type role int
+
+const (
+	roleNone   role = iota
+	roleViewer      // May query status.
+	roleSender      // May also /send and /reply.
+	roleAdmin       // May also retry, change runtime settings and rebind.
+)
+
+func (r role) String() string {
+	switch r {
+	case roleViewer:
+		return "viewer"
+	case roleSender:
+		return "sender"
+	case roleAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
+// roleOf returns the highest role config grants user.
+func (b *Bridge) roleOf(user int64) role {
+	for _, g := range []struct {
+		ids  []int64
+		role role
+	}{{b.config.Admins, roleAdmin}, {b.config.Senders, roleSender}, {b.config.Viewers, roleViewer}} {
+		for _, id := range g.ids {
+			if id == user {
+				return g.role
+			}
+		}
+	}
+	return roleNone
+}
+
+// hasRoles reports whether any Telegram user may use the bot, which is
+// when it needs to receive updates at all.
+func (b *Bridge) hasRoles() bool {
+	return len(b.config.Admins)+len(b.config.Senders)+len(b.config.Viewers) > 0
+}
@@ -0,0 +1,199 @@
+package bridge
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Route sends SMS to destinations starting with Prefix through the first
+// of Smscs whose bind is up.
+type Route struct {
+	Prefix string   // Destination prefix like "+49", every destination if empty.
+	Smscs  []string // SMSC names in order of preference.
+}
+
+// hop is a way to send an SMS: an SMSC, the prefix that led there,
+// without "+", and what it costs, if the route table says.
+type hop struct {
+	smsc   *smsc
+	prefix string
+	price  float64
+	priced bool // The price is from the route table, even if 0.
+}
+
+// tariff is a row of the least-cost routing table.
+type tariff struct {
+	prefix   string
+	smsc     string
+	price    float64
+	priority int // Breaks ties in price, lowest first.
+}
+
+func (b *Bridge) initRoutes() error {
+	for _, r := range b.config.Routes {
+		for _, name := range r.Smscs {
+			if b.smscNamed[name] == nil {
+				return fmt.Errorf("route %q uses unknown SMSC %q", r.Prefix, name)
+			}
+		}
+	}
+	if b.config.Routefile == "" {
+		return nil
+	}
+	rs, err := b.loadTariffs(b.config.Routefile)
+	if err != nil {
+		return fmt.Errorf("can't read route file: %w", err)
+	}
+	b.tariffs.Store(&rs)
+	log.Printf("Loaded %d routes from %s", len(rs), b.config.Routefile)
+	go supervise(b.runCtx, "route file watcher", b.watchTariffs)
+	return nil
+}
+
+// watchTariffs reloads the route table when its file changes. A broken
+// file keeps the previous table in use.
+func (b *Bridge) watchTariffs(ctx context.Context) {
+	var mtime time.Time
+	if fi, err := os.Stat(b.config.Routefile); err == nil {
+		mtime = fi.ModTime()
+	}
+	for sleep(ctx, 5*time.Second) {
+		fi, err := os.Stat(b.config.Routefile)
+		if err != nil || fi.ModTime().Equal(mtime) {
+			continue
+		}
+		mtime = fi.ModTime()
+		rs, err := b.loadTariffs(b.config.Routefile)
+		if err != nil {
+			b.alert("routes", fmt.Sprintf("Can't reload %s, keeping the old routes: %s", b.config.Routefile, err))
+			continue
+		}
+		b.tariffs.Store(&rs)
+		log.Printf("Reloaded %d routes from %s", len(rs), b.config.Routefile)
+	}
+}
+
+// loadTariffs reads a CSV route table with the columns prefix, smsc, price
+// and priority. A first row of column names and lines starting with "#"
+// are skipped.
+func (b *Bridge) loadTariffs(path string) ([]tariff, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = 4
+	r.TrimLeadingSpace = true
+	var rs []tariff
+	for line := 1; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return rs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && rec[0] == "prefix" {
+			continue
+		}
+		price, err := strconv.ParseFloat(rec[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad price %q", path, rec[2])
+		}
+		prio, err := strconv.Atoi(rec[3])
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad priority %q", path, rec[3])
+		}
+		if b.smscNamed[rec[1]] == nil {
+			return nil, fmt.Errorf("%s: unknown SMSC %q", path, rec[1])
+		}
+		rs = append(rs, tariff{strings.TrimPrefix(rec[0], "+"), rec[1], price, prio})
+	}
+}
+
+// routeFor returns the ways to reach dst in order of preference. From the
+// route table, these are the rows with the longest prefix matching dst,
+// cheapest first; else those of the config route with the longest
+// matching prefix, or all SMSCs if none matches. Prefixes match with or
+// without a leading "+". Routes disabled at runtime are passed over.
+func (b *Bridge) routeFor(dst string) []hop {
+	dst = strings.TrimPrefix(dst, "+")
+	if rs := b.tariffs.Load(); rs != nil && len(*rs) > 0 {
+		var best []tariff
+		for _, r := range *rs {
+			switch {
+			case !strings.HasPrefix(dst, r.prefix), b.routeDisabled(r.prefix):
+			case len(best) == 0 || len(r.prefix) > len(best[0].prefix):
+				best = []tariff{r}
+			case len(r.prefix) == len(best[0].prefix):
+				best = append(best, r)
+			}
+		}
+		sort.SliceStable(best, func(i, j int) bool {
+			if best[i].price != best[j].price {
+				return best[i].price < best[j].price
+			}
+			return best[i].priority < best[j].priority
+		})
+		hops := make([]hop, len(best))
+		for i, r := range best {
+			hops[i] = hop{b.smscNamed[r.smsc], r.prefix, r.price, true}
+		}
+		return hops
+	}
+
+	best := -1
+	for i, r := range b.config.Routes {
+		p := strings.TrimPrefix(r.Prefix, "+")
+		if strings.HasPrefix(dst, p) && !b.routeDisabled(p) && (best < 0 || len(p) > len(strings.TrimPrefix(b.config.Routes[best].Prefix, "+"))) {
+			best = i
+		}
+	}
+	var hops []hop
+	if best < 0 {
+		for _, s := range b.smscs {
+			hops = append(hops, hop{smsc: s})
+		}
+		return hops
+	}
+	for _, name := range b.config.Routes[best].Smscs {
+		hops = append(hops, hop{smsc: b.smscNamed[name], prefix: strings.TrimPrefix(b.config.Routes[best].Prefix, "+")})
+	}
+	return hops
+}
+
+// pickRoute returns the first way to reach dst whose bind is up, or the
+// preferred one if none is. The SMSC named by prefer, if any, goes first.
+func (b *Bridge) pickRoute(dst, prefer string) (hop, bool) {
+	hops := b.routeFor(dst)
+	if prefer != "" {
+		first := hop{smsc: b.smscNamed[prefer], prefix: "lookup"}
+		for i, h := range hops {
+			if h.smsc.Name == prefer {
+				first = h
+				hops = append(hops[:i:i], hops[i+1:]...)
+				break
+			}
+		}
+		hops = append([]hop{first}, hops...)
+	}
+	if len(hops) == 0 {
+		return hop{}, false
+	}
+	for _, h := range hops {
+		if h.smsc.watcher.isUp() && h.smsc.current() != nil {
+			return h, true
+		}
+	}
+	return hops[0], true
+}
@@ -0,0 +1,65 @@
+package bridge
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fiorix/go-smpp/smpp"
+)
+
+func TestRoutes(t *testing.T) {
+	smscs := []Smsc{{Name: "a", Address: smscA}, {Name: "b", Address: smscB}}
+	routeFile := filepath.Join(t.TempDir(), "routes.csv")
+	err := os.WriteFile(routeFile, []byte("prefix,smsc,price,priority\n+49,a,0.05,1\n+49,b,0.04,2\n+4930,a,0.02,1\n+4930,b,0,2\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prices := map[string]float64{"+49": 0.06, "+4930": 0.03}
+	routes := []Route{{Prefix: "+49", Smscs: []string{"b", "a"}}, {Prefix: "+4930", Smscs: []string{"a"}}}
+
+	tests := []struct {
+		name  string
+		cfg   Config
+		dst   string
+		down  string // Address of an SMSC whose bind is down.
+		addr  string // Of the SMSC the message goes to.
+		route string
+		price float64
+	}{
+		{"longest prefix", Config{Routes: routes}, "+493012345", "", smscA, "4930", 0},
+		{"preferred SMSC", Config{Routes: routes}, "+4915112345678", "", smscB, "49", 0},
+		{"no route", Config{Routes: routes}, "+33612345678", "", smscA, "", 0},
+		{"failover", Config{Routes: routes}, "+4915112345678", smscB, smscA, "49", 0},
+		{"price list", Config{Routes: routes, Prices: prices}, "+493012345", "", smscA, "4930", 0.03},
+		{"cheapest", Config{Routefile: routeFile, Prices: prices}, "+4915112345678", "", smscB, "49", 0.04},
+		{"free longest prefix", Config{Routefile: routeFile, Prices: prices}, "+493012345", "", smscB, "4930", 0},
+		{"cheapest failover", Config{Routefile: routeFile}, "+4915112345678", smscB, smscA, "49", 0.05},
+		{"longest prefix failover", Config{Routefile: routeFile}, "+493012345", smscB, smscA, "4930", 0.02},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Smscs = smscs
+			tb := startBridge(t, &cfg)
+			waitFor(t, "the binds", func() bool {
+				return tb.smscNamed["a"].watcher.isUp() && tb.smscNamed["b"].watcher.isUp()
+			})
+			if tt.down != "" {
+				tb.smsc.StatusAt(tt.down, smpp.Disconnected)
+				waitFor(t, "the bind to go down", func() bool {
+					return !tb.smscNamed["a"].watcher.isUp() || !tb.smscNamed["b"].watcher.isUp()
+				})
+			}
+
+			m := tb.submit(t, tt.dst, "hi")
+			subs := tb.smsc.Submitted()
+			if len(subs) != 1 || subs[0].Addr != tt.addr {
+				t.Fatalf("Got submits %+v, want one to %s", subs, tt.addr)
+			}
+			if m.Route != tt.route || m.Price != tt.price {
+				t.Errorf("Got route %q at %v, want %q at %v", m.Route, m.Price, tt.route, tt.price)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package bridge
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sharedState holds the deduplication window and the parts of multipart
+// SMS. It lives in Redis when instances share deliveries, so a part
+// arriving at one instance completes a message started at another.
+type sharedState interface {
+	// firstSeen records key for ttl and reports whether it was not seen
+	// within ttl before.
+	firstSeen(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// addPart stores part seq (1-based) of total parts under key and
+	// returns all parts in order once the last one is in, exactly once.
+	addPart(ctx context.Context, key string, seq, total int, part []byte, ttl time.Duration) ([][]byte, error)
+}
+
+// statePrefix namespaces the Redis keys of the bridge.
+const statePrefix = "telegram-smpp-bot:"
+
+func (b *Bridge) initState() {
+	if b.config.State.Address == "" {
+		b.state = newMemoryState()
+		return
+	}
+	log.Printf("Keeping dedup and reassembly state in Redis at %s", b.config.State.Address)
+	b.state = &redisState{c: newRedisClient(b.config.State)}
+}
+
+type memoryState struct {
+	mu    sync.Mutex
+	seen  map[string]time.Time // Expiry by key.
+	parts map[string]*pending
+	swept time.Time
+}
+
+type pending struct {
+	parts   map[int][]byte
+	expires time.Time
+}
+
+func newMemoryState() *memoryState {
+	return &memoryState{seen: make(map[string]time.Time), parts: make(map[string]*pending)}
+}
+
+func (s *memoryState) firstSeen(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	if exp, ok := s.seen[key]; ok && now.Before(exp) {
+		return false, nil
+	}
+	s.seen[key] = now.Add(ttl)
+	return true, nil
+}
+
+func (s *memoryState) addPart(ctx context.Context, key string, seq, total int, part []byte, ttl time.Duration) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	p := s.parts[key]
+	if p == nil {
+		p = &pending{parts: make(map[int][]byte)}
+		s.parts[key] = p
+	}
+	p.parts[seq] = part
+	p.expires = now.Add(ttl)
+	if len(p.parts) < total {
+		return nil, nil
+	}
+	delete(s.parts, key)
+	all := make([][]byte, total)
+	for i := range all {
+		all[i] = p.parts[i+1]
+	}
+	return all, nil
+}
+
+// sweep drops expired entries, at most once a minute.
+func (s *memoryState) sweep(now time.Time) {
+	if now.Sub(s.swept) < time.Minute {
+		return
+	}
+	s.swept = now
+	for k, exp := range s.seen {
+		if now.After(exp) {
+			delete(s.seen, k)
+		}
+	}
+	for k, p := range s.parts {
+		if now.After(p.expires) {
+			log.Printf("Dropped %d of the parts of multipart SMS %q, the rest never came", len(p.parts), k)
+			delete(s.parts, k)
+		}
+	}
+}
+
+type redisState struct {
+	c *redisClient
+}
+
+func (s *redisState) firstSeen(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	_, err := s.c.do(ctx, "SET", statePrefix+"seen:"+key, "1", "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err == redisNil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// addPartScript adds a part and, if that completed the message, returns
+// and deletes all parts in one step, so only one instance gets them.
+const addPartScript = `
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+local total = tonumber(ARGV[3])
+if redis.call('HLEN', KEYS[1]) < total then
+	return nil
+end
+local parts = {}
+for i = 1, total do
+	parts[i] = redis.call('HGET', KEYS[1], tostring(i))
+end
+redis.call('DEL', KEYS[1])
+return parts`
+
+func (s *redisState) addPart(ctx context.Context, key string, seq, total int, part []byte, ttl time.Duration) ([][]byte, error) {
+	v, err := s.c.do(ctx, "EVAL", addPartScript, "1", statePrefix+"parts:"+key,
+		strconv.Itoa(seq), string(part), strconv.Itoa(total), strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err == redisNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	vs, _ := v.([]interface{})
+	all := make([][]byte, len(vs))
+	for i, p := range vs {
+		s, _ := p.(string)
+		all[i] = []byte(s)
+	}
+	return all, nil
+}
@@ -0,0 +1,26 @@
+package bridge
+
+import (
+	"expvar"
+)
+
+// Traffic counters of the process, exported on /debug/vars.
+var (
+	smsIn     = expvar.NewInt("sms_in")  // SMS received from the SMSC.
+	smsOut    = expvar.NewInt("sms_out") // SMS accepted by the SMSC.
+	errsTotal = expvar.NewInt("errors")  // Failed forwards, submits and decodes.
+)
+
+// counters is a snapshot of the traffic counters.
+type counters struct {
+	in, out, errs int64
+}
+
+func snapshot() counters {
+	return counters{in: smsIn.Value(), out: smsOut.Value(), errs: errsTotal.Value()}
+}
+
+// since returns the traffic counted between prev and c.
+func (c counters) since(prev counters) counters {
+	return counters{in: c.in - prev.in, out: c.out - prev.out, errs: c.errs - prev.errs}
+}
@@ -0,0 +1,418 @@
+package bridge
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message directions.
+const (
+	dirIn  = "in"
+	dirOut = "out"
+)
+
+// Message is an SMS as kept in the store.
+type Message struct {
+	ID        int64     `json:"id"`
+	UUID      string    `json:"uuid,omitempty"` // Stable reference for clients and logs, unlike SMSC ids which recycle.
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Src       string    `json:"src"`
+	Dst       string    `json:"dst"`
+	Text      string    `json:"text"`
+	Parts     int       `json:"parts,omitempty"`
+	SMSCID    string    `json:"smsc_id,omitempty"`  // message_id assigned by the SMSC.
+	PartIDs   []string  `json:"part_ids,omitempty"` // message_ids of the further parts.
+	Status    string    `json:"status,omitempty"`   // "submitted", "failed", "rejected", "cancelled" or the receipt state.
+	Error     string    `json:"error,omitempty"`
+	RetryOf   int64     `json:"retry_of,omitempty"` // Message this one resubmits.
+	Retried   bool      `json:"retried,omitempty"`  // Resubmitted already, by Retry.
+	Tenant    string    `json:"tenant,omitempty"`   // API identity that submitted an outbound SMS.
+	TgChat    int64     `json:"tg_chat,omitempty"`  // Telegram chat and message an inbound SMS was forwarded as.
+	TgMessage int64     `json:"tg_message,omitempty"`
+	Smsc      string    `json:"smsc,omitempty"`  // SMSC an outbound SMS was submitted to.
+	Route     string    `json:"route,omitempty"` // Prefix, without "+", of the route that chose the SMSC, or "lookup".
+	Price     float64   `json:"price,omitempty"` // Price per part by the route or the price table.
+	Cost      float64   `json:"cost,omitempty"`  // Estimated cost of all parts.
+	Mcc       string    `json:"mcc,omitempty"`   // Network of the remote number, by lookup or numbering plan.
+	Mnc       string    `json:"mnc,omitempty"`
+	Country   string    `json:"country,omitempty"`  // ISO 3166 code.
+	Encoding  string    `json:"encoding,omitempty"` // "GSM-7" or "UCS-2" for outbound SMS.
+
+	// Detected language of an inbound SMS and the translation, if it
+	// was not in one of the configured languages.
+	Lang        string `json:"lang,omitempty"`
+	Translation string `json:"translation,omitempty"`
+}
+
+// Outbound statuses set before a delivery receipt arrives. Rejected and
+// cancelled messages never reached the SMSC.
+const (
+	statusSubmitted = "submitted"
+	statusFailed    = "failed"
+	statusRejected  = "rejected"
+	statusCancelled = "cancelled"
+)
+
+// neverSent reports whether m is an outbound message that didn't reach the
+// SMSC, which quotas, reports and CDRs leave out.
+func neverSent(m *Message) bool {
+	return m.Status == statusRejected || m.Status == statusCancelled
+}
+
+// Inbound statuses set by moderation.
+const (
+	statusQuarantined = "quarantined"
+	statusReleased    = "released"
+)
+
+// Store keeps messages in memory and, if it has a path, appends every new
+// version of a message to a JSON lines file that is replayed on start.
+type Store struct {
+	mu            sync.Mutex
+	path          string
+	file          *os.File
+	aead          cipher.AEAD                   // Seals message fields in the file, nil to store them as they are.
+	sealAddresses bool                          // Seal the addresses too.
+	cdr           func(m *Message, update bool) // Called with every version of a message, if set.
+	nextID        int64
+	msgs          map[int64]*Message
+	bySMSC        map[string]int64
+	byTg          map[[2]int64]int64
+	byUUID        map[string]int64
+}
+
+// openStore loads the store file at path, creating it if needed. An empty
+// path gives a store that lives in memory only. With a key, see
+// storeCipher, message texts are encrypted in the file, and the addresses
+// too if sealAddresses is set.
+func openStore(path, key string, sealAddresses bool) (*Store, error) {
+	s := &Store{nextID: 1, sealAddresses: sealAddresses, msgs: make(map[int64]*Message), bySMSC: make(map[string]int64), byTg: make(map[[2]int64]int64), byUUID: make(map[string]int64)}
+	if path == "" {
+		return s, nil
+	}
+	if key != "" {
+		var err error
+		if s.aead, err = storeCipher(key); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	plain := false // Some message was written before the key was set.
+	for line := 1; sc.Scan(); line++ {
+		m := new(Message)
+		if err := json.Unmarshal(sc.Bytes(), m); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		plain = plain || !strings.HasPrefix(m.Text, encPrefix)
+		if m.UUID == "" {
+			m.UUID = legacyUUID(m.ID)
+		}
+		if err := s.open(m); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		s.index(m)
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.path, s.file = path, f
+	if s.aead != nil && plain {
+		if err := s.compact(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("can't encrypt %s: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) index(m *Message) {
+	s.msgs[m.ID] = m
+	s.byUUID[m.UUID] = m.ID
+	if m.SMSCID != "" {
+		s.bySMSC[m.SMSCID] = m.ID
+	}
+	for _, id := range m.PartIDs {
+		s.bySMSC[id] = m.ID
+	}
+	if m.TgMessage != 0 {
+		s.byTg[[2]int64{m.TgChat, m.TgMessage}] = m.ID
+	}
+	if m.ID >= s.nextID {
+		s.nextID = m.ID + 1
+	}
+}
+
+// write persists a version of m. Must be called with s.mu held.
+func (s *Store) write(m *Message) error {
+	if s.file == nil {
+		return nil
+	}
+	m, err := s.seal(m)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Write(append(b, '\n'))
+	return err
+}
+
+// Add assigns m an ID, a UUID unless it has one, and a timestamp and
+// stores it.
+func (s *Store) Add(m *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.ID = s.nextID
+	if m.UUID == "" {
+		m.UUID = newUUID()
+	}
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+	c := *m
+	s.index(&c)
+	if s.cdr != nil {
+		s.cdr(&c, false)
+	}
+	return s.write(&c)
+}
+
+// Update applies fn to the stored message id and returns a copy of the
+// result.
+func (s *Store) Update(id int64, fn func(m *Message)) (*Message, error) {
+	return s.UpdateIf(id, func(m *Message) error {
+		fn(m)
+		return nil
+	})
+}
+
+// UpdateIf is Update with a fn that can refuse the change by returning an
+// error, which UpdateIf returns. fn must not change m then.
+func (s *Store) UpdateIf(id int64, fn func(m *Message) error) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.msgs[id]
+	if !ok {
+		return nil, fmt.Errorf("no message %d", id)
+	}
+	if err := fn(m); err != nil {
+		return nil, err
+	}
+	s.index(m)
+	c := *m
+	if s.cdr != nil {
+		s.cdr(&c, true)
+	}
+	return &c, s.write(m)
+}
+
+// Get returns a copy of message id.
+func (s *Store) Get(id int64) (*Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.msgs[id]
+	if !ok {
+		return nil, false
+	}
+	c := *m
+	return &c, true
+}
+
+// BySMSCID returns a copy of the outbound message the SMSC knows as id.
+func (s *Store) BySMSCID(id string) (*Message, bool) {
+	s.mu.Lock()
+	n, ok := s.bySMSC[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return s.Get(n)
+}
+
+// ByTelegram returns a copy of the message forwarded as Telegram message
+// msg in chat.
+func (s *Store) ByTelegram(chat, msg int64) (*Message, bool) {
+	s.mu.Lock()
+	n, ok := s.byTg[[2]int64{chat, msg}]
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return s.Get(n)
+}
+
+// ByUUID returns a copy of the message with UUID u.
+func (s *Store) ByUUID(u string) (*Message, bool) {
+	s.mu.Lock()
+	n, ok := s.byUUID[strings.ToLower(u)]
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return s.Get(n)
+}
+
+// Lookup returns a copy of the message ref names, by UUID or by store ID
+// with an optional leading "#".
+func (s *Store) Lookup(ref string) (*Message, bool) {
+	if id, err := strconv.ParseInt(strings.TrimPrefix(ref, "#"), 10, 64); err == nil {
+		return s.Get(id)
+	}
+	return s.ByUUID(ref)
+}
+
+// Between returns copies of the messages with a time in [from, to), in
+// the order they were stored.
+func (s *Store) Between(from, to time.Time) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ms []Message
+	for _, m := range s.msgs {
+		if !m.Time.Before(from) && m.Time.Before(to) {
+			ms = append(ms, *m)
+		}
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].ID < ms[j].ID })
+	return ms
+}
+
+// Involving returns copies of the messages from or to number, in the
+// order they were stored. A leading "+" is ignored.
+func (s *Store) Involving(number string) []Message {
+	number = strings.TrimPrefix(number, "+")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ms []Message
+	for _, m := range s.msgs {
+		if strings.TrimPrefix(m.Src, "+") == number || strings.TrimPrefix(m.Dst, "+") == number {
+			ms = append(ms, *m)
+		}
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].ID < ms[j].ID })
+	return ms
+}
+
+// Delete removes messages and rewrites the store file without any of
+// their versions.
+func (s *Store) Delete(ids []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		m, ok := s.msgs[id]
+		if !ok {
+			continue
+		}
+		delete(s.msgs, id)
+		delete(s.bySMSC, m.SMSCID)
+		for _, p := range m.PartIDs {
+			delete(s.bySMSC, p)
+		}
+		delete(s.byTg, [2]int64{m.TgChat, m.TgMessage})
+		delete(s.byUUID, m.UUID)
+	}
+	return s.compact()
+}
+
+// compact replaces the store file with one holding the current version of
+// every message. Must be called with s.mu held.
+func (s *Store) compact() error {
+	if s.file == nil {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".store-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	ids := make([]int64, 0, len(s.msgs))
+	for id := range s.msgs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	w := bufio.NewWriter(tmp)
+	for _, id := range ids {
+		m, err := s.seal(s.msgs[id])
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	s.file.Close()
+	s.file = f
+	return nil
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return formatUUID(b, 0x40)
+}
+
+// uuidRe matches the UUIDs of messages, in either case.
+var uuidRe = regexp.MustCompile(`^[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}$`)
+
+// legacyUUID is the UUID of a message stored before messages had one,
+// derived from its ID so that it stays the same across restarts.
+func legacyUUID(id int64) string {
+	sum := sha1.Sum([]byte("telegram-smpp-bot/message/" + strconv.FormatInt(id, 10)))
+	var b [16]byte
+	copy(b[:], sum[:])
+	return formatUUID(b, 0x50)
+}
+
+// formatUUID sets the version and RFC 4122 variant bits of b.
+func formatUUID(b [16]byte, version byte) string {
+	b[6] = b[6]&0x0f | version
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
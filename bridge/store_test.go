@@ -0,0 +1,151 @@
+package bridge
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Store keys for the tests, base64 AES-256.
+const (
+	testKey  = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
+	otherKey = "ICEiIyQlJicoKSorLC0uLzAxMjM0NTY3ODk6Ozw9Pj8="
+)
+
+func TestSealedStore(t *testing.T) {
+	const dst, text = "+4915112345678", "the secret text"
+	tests := []struct {
+		name      string
+		key       string
+		addresses bool
+		sealed    []string // Values that must not be in the file.
+		plain     []string // Values that must be.
+		reopen    string   // Key to open the file again with.
+		err       string   // Wanted error of opening it, if any.
+	}{
+		{"no key", "", false, nil, []string{text, dst}, "", ""},
+		{"key", testKey, false, []string{text}, []string{dst, encPrefix}, testKey, ""},
+		{"key and addresses", testKey, true, []string{text, dst}, []string{encPrefix}, testKey, ""},
+		{"without the key", testKey, false, nil, nil, "", "there is no store key"},
+		{"with another key", testKey, true, nil, nil, otherKey, "can't decrypt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "store.jsonl")
+			tb := startBridge(t, &Config{Storepath: path, Storekey: tt.key, Encryptaddresses: tt.addresses})
+			m := tb.submit(t, dst, text)
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range tt.sealed {
+				if strings.Contains(string(b), v) {
+					t.Errorf("Store file has %q in the clear:\n%s", v, b)
+				}
+			}
+			for _, v := range tt.plain {
+				if !strings.Contains(string(b), v) {
+					t.Errorf("Store file lacks %q:\n%s", v, b)
+				}
+			}
+
+			s, err := openStore(path, tt.reopen, tt.addresses)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := s.Get(m.ID)
+			if !ok {
+				t.Fatalf("No message %d after reopening", m.ID)
+			}
+			// As JSON, like the store writes them.
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(m)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("Got %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.jsonl")
+	// Message 1 was stored before messages had a UUID.
+	err := os.WriteFile(path, []byte(`{"id":1,"time":"2024-01-02T03:04:05Z","direction":"in","src":"+491","dst":"TEST","text":"old"}`+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := openStore(path, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Message{Direction: dirOut, Src: "TEST", Dst: "+492", Text: "new"}
+	if err := s.Add(m); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ref string
+		id  int64 // 0 if unknown.
+	}{
+		{m.UUID, m.ID},
+		{strings.ToUpper(m.UUID), m.ID},
+		{"#" + strconv.FormatInt(m.ID, 10), m.ID},
+		{strconv.FormatInt(m.ID, 10), m.ID},
+		{legacyUUID(1), 1},
+		{legacyUUID(2), 0},
+		{"#99", 0},
+		{"nonsense", 0},
+	}
+	for _, tt := range tests {
+		got, ok := s.Lookup(tt.ref)
+		switch {
+		case tt.id == 0 && ok:
+			t.Errorf("Lookup(%q) = message %d, want none", tt.ref, got.ID)
+		case tt.id != 0 && (!ok || got.ID != tt.id):
+			t.Errorf("Lookup(%q) = %+v, %v, want message %d", tt.ref, got, ok, tt.id)
+		}
+	}
+
+	// The legacy UUID is the same after a restart, and the new one kept.
+	s, err = openStore(path, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ref, id := range map[string]int64{legacyUUID(1): 1, m.UUID: m.ID} {
+		if got, ok := s.ByUUID(ref); !ok || got.ID != id {
+			t.Errorf("After reopening ByUUID(%q) = %+v, %v, want message %d", ref, got, ok, id)
+		}
+	}
+}
+
+func TestSealedTranslation(t *testing.T) {
+	const translation = "the secret translation"
+	path := filepath.Join(t.TempDir(), "store.jsonl")
+	s, err := openStore(path, testKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Message{Direction: dirIn, Src: "+491", Dst: "TEST", Text: "das Geheimnis", Lang: "de", Translation: translation}
+	if err := s.Add(m); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(path); err != nil || strings.Contains(string(b), translation) {
+		t.Fatalf("Got store file %s, %v, want the translation sealed", b, err)
+	}
+	if s, err = openStore(path, testKey, false); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := s.Get(m.ID); !ok || got.Translation != translation {
+		t.Errorf("Got %+v after reopening, want translation %q", got, translation)
+	}
+}
@@ -0,0 +1,116 @@
+package bridge
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// encPrefix marks a field sealed with the store key.
+const encPrefix = "enc:v1:"
+
+// storeCipher makes the AEAD for the store file from key: a base64
+// AES-256 key, "file:/path" to read it from a file (such as one a KMS
+// agent writes) or "env:NAME" to read it from the environment.
+func storeCipher(key string) (cipher.AEAD, error) {
+	switch {
+	case strings.HasPrefix(key, "file:"):
+		b, err := os.ReadFile(strings.TrimPrefix(key, "file:"))
+		if err != nil {
+			return nil, err
+		}
+		key = strings.TrimSpace(string(b))
+	case strings.HasPrefix(key, "env:"):
+		key = os.Getenv(strings.TrimPrefix(key, "env:"))
+	}
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("store key: %w", err)
+	}
+	if len(k) != 32 {
+		return nil, fmt.Errorf("store key: want 32 bytes, got %d", len(k))
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns a copy of m for the store file with the text and its
+// translation, and the addresses if the store seals them, encrypted. Each field is
+// bound to the message ID and its name, so sealed values can't be moved.
+func (s *Store) seal(m *Message) (*Message, error) {
+	if s.aead == nil {
+		return m, nil
+	}
+	c := *m
+	var err error
+	if c.Text, err = s.sealField(m.ID, "text", m.Text); err != nil {
+		return nil, err
+	}
+	if m.Translation != "" {
+		if c.Translation, err = s.sealField(m.ID, "translation", m.Translation); err != nil {
+			return nil, err
+		}
+	}
+	if s.sealAddresses {
+		if c.Src, err = s.sealField(m.ID, "src", m.Src); err != nil {
+			return nil, err
+		}
+		if c.Dst, err = s.sealField(m.ID, "dst", m.Dst); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// open decrypts the sealed fields of m read from the store file in place.
+// Fields written before encryption was turned on are left as they are.
+func (s *Store) open(m *Message) error {
+	var err error
+	if m.Text, err = s.openField(m.ID, "text", m.Text); err != nil {
+		return err
+	}
+	if m.Translation, err = s.openField(m.ID, "translation", m.Translation); err != nil {
+		return err
+	}
+	if m.Src, err = s.openField(m.ID, "src", m.Src); err != nil {
+		return err
+	}
+	m.Dst, err = s.openField(m.ID, "dst", m.Dst)
+	return err
+}
+
+func (s *Store) sealField(id int64, name, v string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	b := s.aead.Seal(nonce, nonce, []byte(v), []byte(strconv.FormatInt(id, 10)+":"+name))
+	return encPrefix + base64.StdEncoding.EncodeToString(b), nil
+}
+
+func (s *Store) openField(id int64, name, v string) (string, error) {
+	if !strings.HasPrefix(v, encPrefix) {
+		return v, nil
+	}
+	if s.aead == nil {
+		return "", fmt.Errorf("message %d is encrypted and there is no store key", id)
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(v, encPrefix))
+	if err != nil || len(b) < s.aead.NonceSize() {
+		return "", fmt.Errorf("message %d: bad encrypted %s", id, name)
+	}
+	n := s.aead.NonceSize()
+	p, err := s.aead.Open(nil, b[:n], b[n:], []byte(strconv.FormatInt(id, 10)+":"+name))
+	if err != nil {
+		return "", fmt.Errorf("message %d: can't decrypt %s: %w", id, name, err)
+	}
+	return string(p), nil
+}
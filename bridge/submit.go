@@ -0,0 +1,133 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fiorix/go-smpp/smpp"
+	"golang.org/x/time/rate"
+
+	"telegram-smpp-bot/smppclient"
+)
+
+// Submits rejected with 429 because the pipeline was saturated.
+var submitsRejected = expvar.NewInt("submits_rejected")
+
+func init() {
+	expvar.Publish("submit_queue_depth", expvar.Func(func() interface{} {
+		n := 0
+		eachRunning(func(b *Bridge) { n += len(b.submitSlots) })
+		return n
+	}))
+}
+
+// busyError is returned by submit when the message can't be accepted right
+// now without blocking the caller for longer than config.Queuewait.
+type busyError struct {
+	reason string
+	retry  time.Duration // Suggested delay before the next attempt.
+}
+
+func (e *busyError) Error() string {
+	return e.reason
+}
+
+// startSubmitQueue sizes the pipeline from config. Must be called before
+// the first submit.
+func (b *Bridge) startSubmitQueue() {
+	b.submitSlots = make(chan struct{}, b.config.Queuesize)
+}
+
+// submit sends sm, the message m split into the given number of parts,
+// through the rate limiters and tx and returns the SMSC message ids of the
+// parts. It fails fast with a *busyError when either is saturated instead
+// of queueing indefinitely, gives up waiting for the limiters or the SMSC
+// when ctx is done, and returns errCancelled if m was cancelled while it
+// waited for the limiters.
+func (b *Bridge) submit(ctx context.Context, m *Message, tx smppclient.Transceiver, sm *smpp.ShortMessage, parts int, scoped []*scopedLimiter) ([]string, error) {
+	select {
+	case b.submitSlots <- struct{}{}:
+		defer func() { <-b.submitSlots }()
+	default:
+		// Every queued submit needs a limiter token, so the queue drains
+		// at roughly the limiter rate.
+		wait := time.Duration(float64(len(b.submitSlots)) / float64(b.limiter.Limit()) * float64(time.Second))
+		return nil, &busyError{reason: "submit queue is full", retry: wait}
+	}
+
+	// Each part takes a token; waiting for the last one keeps the average
+	// rate of a long message within the limit.
+	var rs []*rate.Reservation
+	var d time.Duration
+	for i := 0; i < parts; i++ {
+		r := b.limiter.Reserve()
+		rs = append(rs, r)
+		d = r.Delay()
+	}
+	scope := "global"
+	for _, l := range scoped {
+		for i := 0; i < parts; i++ {
+			r := l.Reserve()
+			rs = append(rs, r)
+			if r.Delay() > d {
+				d, scope = r.Delay(), l.Name
+			}
+		}
+	}
+	cancel := func() {
+		for i := len(rs) - 1; i >= 0; i-- {
+			rs[i].Cancel()
+		}
+	}
+	if d > b.config.Queuewait.Duration {
+		cancel()
+		throttledByScope.Add(scope, 1)
+		return nil, &busyError{reason: "rate limit exceeded for " + scope, retry: d}
+	}
+	wait, stop := context.WithCancel(ctx)
+	defer stop()
+	b.waiting.wait(m.UUID, m.Tenant, stop)
+	waited := sleep(wait, d)
+	if !b.waiting.done(m.UUID) {
+		cancel()
+		return nil, errCancelled
+	}
+	if !waited {
+		cancel()
+		return nil, ctx.Err()
+	}
+
+	ids, err := tx.Submit(ctx, sm, parts)
+	if err == smpp.ErrMaxWindowSize {
+		return nil, &busyError{reason: "SMPP window is full", retry: time.Second}
+	}
+	return ids, err
+}
+
+// writeBusy answers a request rejected by submit with 429, a Retry-After
+// header and the current queue depth.
+func (b *Bridge) writeBusy(w http.ResponseWriter, busy *busyError) {
+	submitsRejected.Add(1)
+	b.alert("queue", fmt.Sprintf("Submit rejected: %s, queue depth %d/%d", busy.reason, len(b.submitSlots), cap(b.submitSlots)))
+	retry := int(math.Ceil(busy.retry.Seconds()))
+	if retry < 1 {
+		retry = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	w.Header().Set("X-Queue-Depth", strconv.Itoa(len(b.submitSlots)))
+	w.Header().Set("X-Queue-Capacity", strconv.Itoa(cap(b.submitSlots)))
+	http.Error(w, fmt.Sprintf("%s, queue depth %d/%d", busy.reason, len(b.submitSlots), cap(b.submitSlots)), http.StatusTooManyRequests)
+}
+
+// isBusy reports whether err is a *busyError and returns it.
+func isBusy(err error) (*busyError, bool) {
+	var busy *busyError
+	ok := errors.As(err, &busy)
+	return busy, ok
+}
@@ -0,0 +1,58 @@
+package bridge
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+// Number of recovered panics per worker name, exported on /debug/vars.
+var panics = expvar.NewMap("panics")
+
+// Delay before a crashed worker is started again.
+const restartDelay = time.Second
+
+// recoverPanic must be deferred by the caller. It swallows a panic, logs it
+// with the stack trace and counts it, so the caller's goroutine survives.
+func recoverPanic(name string) {
+	if r := recover(); r != nil {
+		log.Printf("Panic in %s: %v\n%s", name, r, debug.Stack())
+		panics.Add(name, 1)
+	}
+}
+
+// supervise runs fn until it returns normally or ctx is done. If fn
+// panics, the panic is logged and counted and fn is started again after a
+// short delay.
+func supervise(ctx context.Context, name string, fn func(context.Context)) {
+	for {
+		if run(ctx, name, fn) {
+			return
+		}
+		log.Printf("Restarting %s in %s", name, restartDelay)
+		if !sleep(ctx, restartDelay) {
+			return
+		}
+	}
+}
+
+// run calls fn and reports whether it returned without panicking.
+func run(ctx context.Context, name string, fn func(context.Context)) (ok bool) {
+	defer recoverPanic(name)
+	fn(ctx)
+	return true
+}
+
+// sleep pauses for d and reports whether ctx is still live afterwards.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,116 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"telegram-smpp-bot/telegramsink"
+)
+
+// Event classes. Each class can be routed to its own chat or topic with
+// the "events" config section.
+const (
+	eventSMS = "sms" // Inbound SMS.
+	eventDLR = "dlr" // Delivery receipts.
+	eventOps = "ops" // Connection state and error notifications.
+
+	eventQuarantine = "quarantine" // Inbound SMS held back by moderation.
+)
+
+// Destination is a chat and optionally a forum topic in it.
+type Destination struct {
+	Chat  string
+	Topic string
+}
+
+// destination returns where messages of an event class go. Inbound SMS go
+// to the main chat (its topic when the chat type is "topic"), receipts
+// follow the SMS and operational events and quarantined SMS go to the ops
+// chat, never to the main one. An "events" entry overrides the chat, the topic or both.
+func (b *Bridge) destination(class string) Destination {
+	var d Destination
+	switch class {
+	case eventDLR:
+		d = b.destination(eventSMS)
+	case eventOps, eventQuarantine:
+		d = Destination{Chat: b.config.OpsChatid}
+	default:
+		d = Destination{Chat: b.config.Chatid}
+		if b.config.Chattype == "topic" {
+			d.Topic = b.config.Chattopic
+		}
+	}
+	if o, ok := b.config.Events[class]; ok {
+		if o.Chat != "" {
+			d = Destination{Chat: o.Chat}
+		}
+		if o.Topic != "" {
+			d.Topic = o.Topic
+		}
+	}
+	return d
+}
+
+// sendOps posts an operational notification. It has no caller to answer
+// to and runs until shutdown at most. Failures are logged but not alerted
+// on, as the alert would take the same way.
+func (b *Bridge) sendOps(m string) {
+	defer recoverPanic("telegram sender")
+
+	d := b.destination(eventOps)
+	if d.Chat == "" {
+		log.Printf("No ops chat configured, not sending: %s", m)
+		return
+	}
+	if err := b.sendTo(b.runCtx, d.Chat, d.Topic, m); err != nil {
+		log.Printf("Can't send ops message to Telegram. Error: %s", err)
+	}
+}
+
+// sendEvent posts m to the destination of class and returns the sent
+// message. Errors are only logged and give nil.
+func (b *Bridge) sendEvent(ctx context.Context, class, m string) *telegramsink.Message {
+	defer recoverPanic("telegram sender")
+
+	sent, err := b.send(ctx, b.destination(class), m, nil)
+	if err != nil {
+		log.Printf("Can't send message to Telegram. Error: %s", err)
+		errsTotal.Add(1)
+		b.alert(errorClass(err), fmt.Sprintf("Dropped %s message, can't forward it to Telegram: %s", class, err))
+	}
+	return sent
+}
+
+// sendTo posts an HTML message to chat, replying to topic if it isn't empty.
+func (b *Bridge) sendTo(ctx context.Context, chat, topic, m string) error {
+	_, err := b.send(ctx, Destination{Chat: chat, Topic: topic}, m, nil)
+	return err
+}
+
+// send posts an HTML message to d with an optional reply markup such as an
+// inline keyboard and returns the sent message.
+func (b *Bridge) send(ctx context.Context, d Destination, m string, markup interface{}) (*telegramsink.Message, error) {
+	return b.tg.Send(ctx, d.Chat, d.Topic, m, markup)
+}
+
+// call invokes a Bot API method and decodes its result into result unless
+// it is nil.
+func (b *Bridge) call(ctx context.Context, method string, form map[string]string, result interface{}) error {
+	return b.tg.Call(ctx, method, form, result)
+}
+
+// upload is call with files attached, given as field name to path.
+func (b *Bridge) upload(ctx context.Context, method string, form, files map[string]string, result interface{}) error {
+	return b.tg.Upload(ctx, method, form, files, result)
+}
+
+// errorClass names the alert class of a failed Telegram call.
+func errorClass(err error) string {
+	var apiErr *telegramsink.APIError
+	if errors.As(err, &apiErr) {
+		return fmt.Sprintf("telegram:%d", apiErr.Code)
+	}
+	return "telegram:network"
+}
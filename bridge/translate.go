@@ -0,0 +1,85 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Translation detects the language of inbound SMS with a LibreTranslate
+// compatible API and translates those the team doesn't read.
+type Translation struct {
+	Url       string   // API base like https://translate.example.com, off if empty.
+	Apikey    string   // Sent as api_key, optional.
+	Languages []string // ISO 639-1 codes of languages forwarded as they are.
+	Target    string   // Language to translate into, the first of Languages if empty.
+	Timeout   Duration // Per call; past it the SMS is forwarded untranslated.
+}
+
+// translateSMS sets the language of inbound m and, if it is not one of
+// config.Translation.Languages, its translation. Failures leave m as it
+// is, so the SMS goes out untranslated.
+func (b *Bridge) translateSMS(ctx context.Context, m *Message) {
+	t := b.config.Translation
+	if t.Url == "" || strings.TrimSpace(m.Text) == "" {
+		return
+	}
+	var detected []struct {
+		Language   string
+		Confidence float64
+	}
+	if err := b.translateCall(ctx, "/detect", map[string]string{"q": m.Text}, &detected); err != nil {
+		log.Printf("Can't detect language of SMS from %s. Error: %s", b.mask(m.Src), err)
+		return
+	}
+	if len(detected) == 0 {
+		return
+	}
+	m.Lang = detected[0].Language
+	for _, l := range t.Languages {
+		if strings.EqualFold(l, m.Lang) {
+			return
+		}
+	}
+	var res struct {
+		TranslatedText string
+	}
+	err := b.translateCall(ctx, "/translate", map[string]string{"q": m.Text, "source": m.Lang, "target": t.Target, "format": "text"}, &res)
+	if err != nil {
+		log.Printf("Can't translate SMS from %s. Error: %s", b.mask(m.Src), err)
+		return
+	}
+	m.Translation = res.TranslatedText
+}
+
+func (b *Bridge) translateCall(ctx context.Context, path string, req map[string]string, result interface{}) error {
+	if b.config.Translation.Apikey != "" {
+		req["api_key"] = b.config.Translation.Apikey
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, b.config.Translation.Timeout.Duration)
+	defer cancel()
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(b.config.Translation.Url, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	resp, err := b.serviceClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("translation service %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
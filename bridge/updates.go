@@ -0,0 +1,259 @@
+package bridge
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"telegram-smpp-bot/api"
+	"telegram-smpp-bot/telegramsink"
+)
+
+// Update kinds the bot asks Telegram for.
+const allowedUpdates = `["message","callback_query"]`
+
+// startUpdates starts receiving updates the way config.Updates says:
+// "polling" (the default) long-polls getUpdates and works behind NAT,
+// "webhook" has Telegram post them to config.Webhookurl, which must reach
+// this server's HTTPS listener.
+func (b *Bridge) startUpdates(ctx context.Context) error {
+	switch b.config.Updates {
+	case "webhook":
+		u, err := url.Parse(b.config.Webhookurl)
+		if err != nil || u.Scheme != "https" || u.Path == "" || u.Path == "/" {
+			return fmt.Errorf("bad webhookurl %q, want an https URL with a path", b.config.Webhookurl)
+		}
+		if b.config.Webhooksecret == "" {
+			return errors.New("webhook mode needs webhooksecret")
+		}
+		b.handle(api.GroupTelegram, u.Path, b.handleWebhook)
+		go supervise(ctx, "telegram webhook", b.setWebhook)
+	case "", "polling":
+		go supervise(ctx, "telegram updates", func(ctx context.Context) {
+			// getUpdates is refused while a webhook is set.
+			for {
+				err := b.call(ctx, "deleteWebhook", nil, nil)
+				if err == nil {
+					break
+				}
+				log.Printf("Can't delete Telegram webhook. Error: %s", err)
+				if !sleep(ctx, 5*time.Second) {
+					return
+				}
+			}
+			b.pollUpdates(ctx)
+		})
+	default:
+		return fmt.Errorf("unknown updates mode %q", b.config.Updates)
+	}
+	return nil
+}
+
+// setWebhook registers config.Webhookurl with Telegram, retrying until it
+// succeeds. A self-signed certificate is uploaded along.
+func (b *Bridge) setWebhook(ctx context.Context) {
+	form := map[string]string{
+		"url":             b.config.Webhookurl,
+		"secret_token":    b.config.Webhooksecret,
+		"allowed_updates": allowedUpdates,
+	}
+	var files map[string]string
+	if b.config.Webhookselfsigned {
+		files = map[string]string{"certificate": b.config.Certfile}
+	}
+	for {
+		err := b.upload(ctx, "setWebhook", form, files, nil)
+		if err == nil {
+			log.Printf("Telegram webhook set to %s", b.config.Webhookurl)
+			return
+		}
+		log.Printf("Can't set Telegram webhook. Error: %s", err)
+		if !sleep(ctx, 30*time.Second) {
+			return
+		}
+	}
+}
+
+// handleWebhook receives an update posted by Telegram.
+func (b *Bridge) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(b.config.Webhooksecret)) != 1 {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var u telegramsink.Update
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Answer at once, Telegram waits for the reply before the next update.
+	// The update outlives the request, so it is bound to the bridge only.
+	go b.handleUpdate(b.runCtx, u)
+}
+
+// pollUpdates receives updates from Telegram by long polling.
+func (b *Bridge) pollUpdates(ctx context.Context) {
+	var offset int64
+	for ctx.Err() == nil {
+		if !b.isLeader() {
+			// Telegram hands updates to one poller only.
+			sleep(ctx, time.Second)
+			continue
+		}
+		var updates []telegramsink.Update
+		err := b.call(ctx, "getUpdates", map[string]string{
+			"offset":          strconv.FormatInt(offset, 10),
+			"timeout":         strconv.Itoa(telegramsink.PollTimeout),
+			"allowed_updates": allowedUpdates,
+		}, &updates)
+		if err != nil {
+			log.Printf("Can't get updates from Telegram. Error: %s", err)
+			sleep(ctx, 5*time.Second)
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			b.handleUpdate(ctx, u)
+		}
+	}
+}
+
+func (b *Bridge) handleUpdate(ctx context.Context, u telegramsink.Update) {
+	defer recoverPanic("update handler")
+
+	if b.debugLevel.Load() < 2 && !b.config.Masknumbers {
+		log.Printf("Telegram update: %+v", u)
+	}
+	if q := u.CallbackQuery; q != nil {
+		b.handleCallback(ctx, q)
+	}
+	if msg := u.Message; msg != nil && msg.From != nil {
+		b.handleMessage(ctx, msg)
+	}
+}
+
+// handleMessage runs bot commands and feeds other messages to a running
+// /send wizard.
+func (b *Bridge) handleMessage(ctx context.Context, msg *telegramsink.Message) {
+	cmd, args, ok := parseCommand(msg.Text)
+	if !ok {
+		if b.roleOf(msg.From.ID) >= roleSender {
+			b.continueWizard(ctx, msg)
+		}
+		return
+	}
+	b.runCommand(ctx, msg, cmd, args)
+}
+
+// parseCommand splits "/cmd@bot args" into the command and its arguments.
+func parseCommand(text string) (cmd, args string, ok bool) {
+	if !strings.HasPrefix(text, "/") {
+		return "", "", false
+	}
+	cmd, args, _ = strings.Cut(text[1:], " ")
+	cmd, _, _ = strings.Cut(cmd, "@")
+	return strings.ToLower(cmd), strings.TrimSpace(args), true
+}
+
+// handleCallback runs the action of an inline button.
+func (b *Bridge) handleCallback(ctx context.Context, q *telegramsink.CallbackQuery) {
+	action, arg, _ := strings.Cut(q.Data, ":")
+	switch action {
+	case "retry":
+		if b.roleOf(q.From.ID) < roleAdmin {
+			b.answerCallback(ctx, q, "Only admins can retry messages")
+			return
+		}
+		b.answerCallback(ctx, q, b.retry(ctx, arg, q.Message))
+	case "release":
+		if b.roleOf(q.From.ID) < roleAdmin {
+			b.answerCallback(ctx, q, "Only admins can release messages")
+			return
+		}
+		b.answerCallback(ctx, q, b.release(ctx, arg, q.Message))
+	case "send":
+		if b.roleOf(q.From.ID) < roleSender {
+			b.answerCallback(ctx, q, "Only senders can send SMS")
+			return
+		}
+		b.finishWizard(ctx, q, arg)
+	default:
+		b.answerCallback(ctx, q, "Unknown action")
+	}
+}
+
+// errAlreadyRetried is returned for a Retry of a message resubmitted
+// already.
+var errAlreadyRetried = errors.New("already retried")
+
+// retry resubmits the stored message with the given id, once, and returns
+// the outcome for the user. On success the Retry button is removed from
+// msg.
+func (b *Bridge) retry(ctx context.Context, arg string, msg *telegramsink.Message) string {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return "Bad message id"
+	}
+	if _, ok := b.store.Get(id); !ok {
+		return fmt.Sprintf("Message #%d is not in the store", id)
+	}
+	// Claimed under the store lock, so that pressing Retry twice, or two
+	// admins pressing it, resubmits once.
+	orig, err := b.store.UpdateIf(id, func(m *Message) error {
+		if m.Retried {
+			return errAlreadyRetried
+		}
+		m.Retried = true
+		return nil
+	})
+	if err != nil {
+		return fmt.Sprintf("Message #%d: %s", id, err)
+	}
+	log.Printf("Retrying message %d to %s", id, b.mask(orig.Dst))
+	m := &Message{Src: orig.Src, Dst: orig.Dst, Text: orig.Text, RetryOf: orig.ID, Tenant: orig.Tenant}
+	if err := b.sendSMS(ctx, m); err != nil {
+		if m.Status != statusFailed {
+			// It didn't reach the SMSC, so it may be retried again.
+			if _, err := b.store.Update(id, func(m *Message) { m.Retried = false }); err != nil {
+				log.Printf("Can't update message %d. Error: %s", id, err)
+			}
+		}
+		return "Retry failed: " + err.Error()
+	}
+	b.removeKeyboard(ctx, msg)
+	return fmt.Sprintf("Resubmitted as #%d", m.ID)
+}
+
+// removeKeyboard takes the inline buttons off msg, if there is one.
+func (b *Bridge) removeKeyboard(ctx context.Context, msg *telegramsink.Message) {
+	if msg == nil {
+		return
+	}
+	err := b.call(ctx, "editMessageReplyMarkup", map[string]string{
+		"chat_id":      strconv.FormatInt(msg.Chat.ID, 10),
+		"message_id":   strconv.FormatInt(msg.MessageID, 10),
+		"reply_markup": `{"inline_keyboard":[]}`,
+	}, nil)
+	if err != nil {
+		log.Printf("Can't remove inline buttons. Error: %s", err)
+	}
+}
+
+func (b *Bridge) answerCallback(ctx context.Context, q *telegramsink.CallbackQuery, text string) {
+	err := b.call(ctx, "answerCallbackQuery", map[string]string{"callback_query_id": q.ID, "text": text}, nil)
+	if err != nil {
+		log.Printf("Can't answer callback query. Error: %s", err)
+	}
+}
@@ -0,0 +1,164 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-smpp-bot/smppclient"
+	"telegram-smpp-bot/telegramsink"
+)
+
+// A /send wizard left alone for this long is forgotten.
+const wizardTTL = 10 * time.Minute
+
+// Wizard steps.
+const (
+	askRecipient = iota
+	askText
+	askConfirm
+)
+
+// wizard is a /send conversation with one user in one chat.
+type wizard struct {
+	step    int
+	dst     string
+	text    string
+	updated time.Time
+}
+
+type wizardMap struct {
+	sync.Mutex
+	m map[wizardKey]*wizard
+}
+
+type wizardKey struct {
+	chat, user int64
+}
+
+var recipientRe = regexp.MustCompile(`^\+?[0-9]{3,20}$`)
+
+// startWizard begins a /send conversation by asking for the recipient.
+func (b *Bridge) startWizard(ctx context.Context, msg *telegramsink.Message) {
+	b.wizards.Lock()
+	b.wizards.m[wizardKey{msg.Chat.ID, msg.From.ID}] = &wizard{step: askRecipient, updated: time.Now()}
+	b.wizards.Unlock()
+	b.reply(ctx, msg, "📱 Recipient number?", telegramsink.ForceReply{ForceReply: true, Selective: true, Hint: "+491701234567"})
+}
+
+// cancelWizard drops the user's conversation, if any.
+func (b *Bridge) cancelWizard(ctx context.Context, msg *telegramsink.Message) {
+	b.wizards.Lock()
+	k := wizardKey{msg.Chat.ID, msg.From.ID}
+	_, ok := b.wizards.m[k]
+	delete(b.wizards.m, k)
+	b.wizards.Unlock()
+	if ok {
+		b.reply(ctx, msg, "Cancelled.", nil)
+	}
+}
+
+// continueWizard feeds a plain message to the user's conversation and
+// reports whether there was one.
+func (b *Bridge) continueWizard(ctx context.Context, msg *telegramsink.Message) bool {
+	b.wizards.Lock()
+	defer b.wizards.Unlock()
+
+	k := wizardKey{msg.Chat.ID, msg.From.ID}
+	w, ok := b.wizards.m[k]
+	if !ok {
+		return false
+	}
+	if time.Since(w.updated) > wizardTTL {
+		delete(b.wizards.m, k)
+		return false
+	}
+	w.updated = time.Now()
+
+	switch w.step {
+	case askRecipient:
+		dst := strings.Join(strings.Fields(msg.Text), "")
+		if !recipientRe.MatchString(dst) {
+			go b.reply(ctx, msg, "That doesn't look like a phone number, try again or /cancel.", telegramsink.ForceReply{ForceReply: true, Selective: true})
+			return true
+		}
+		w.dst = dst
+		w.step = askText
+		go b.reply(ctx, msg, "✉️ Text?", telegramsink.ForceReply{ForceReply: true, Selective: true})
+	case askText:
+		if msg.Text == "" {
+			go b.reply(ctx, msg, "The text can't be empty, try again or /cancel.", telegramsink.ForceReply{ForceReply: true, Selective: true})
+			return true
+		}
+		w.text = msg.Text
+		w.step = askConfirm
+		_, enc, parts := smppclient.Encoding(w.text)
+		summary := fmt.Sprintf("To: %s\nEncoding: %s, %d part(s)\n\n%s", html.EscapeString(b.mask(w.dst)), enc, parts, html.EscapeString(w.text))
+		go b.reply(ctx, msg, summary, telegramsink.InlineKeyboard{InlineKeyboard: [][]telegramsink.InlineButton{{
+			{Text: "✅ Confirm", CallbackData: "send:confirm"},
+			{Text: "✖️ Cancel", CallbackData: "send:cancel"},
+		}}})
+	}
+	return true
+}
+
+// finishWizard handles the Confirm and Cancel buttons of a summary.
+func (b *Bridge) finishWizard(ctx context.Context, q *telegramsink.CallbackQuery, action string) {
+	if q.Message == nil {
+		b.answerCallback(ctx, q, "Message is gone")
+		return
+	}
+	k := wizardKey{q.Message.Chat.ID, q.From.ID}
+	b.wizards.Lock()
+	w, ok := b.wizards.m[k]
+	if ok && w.step == askConfirm {
+		delete(b.wizards.m, k)
+	}
+	b.wizards.Unlock()
+	if !ok || w.step != askConfirm || time.Since(w.updated) > wizardTTL {
+		b.answerCallback(ctx, q, "Nothing to confirm, start again with /send")
+		return
+	}
+
+	if action != "confirm" {
+		b.answerCallback(ctx, q, "Cancelled")
+		b.editText(ctx, q.Message, "✖️ Cancelled.")
+		return
+	}
+	log.Printf("User %d sends SMS to %s from Telegram", q.From.ID, b.mask(w.dst))
+	m := &Message{Src: b.config.Source, Dst: w.dst, Text: w.text}
+	if err := b.sendSMS(ctx, m); err != nil {
+		b.answerCallback(ctx, q, "Failed: "+err.Error())
+		b.editText(ctx, q.Message, "❌ Sending to "+html.EscapeString(b.mask(w.dst))+" failed: "+html.EscapeString(err.Error()))
+		return
+	}
+	b.answerCallback(ctx, q, "Sent")
+	b.editText(ctx, q.Message, fmt.Sprintf("✅ Sent to %s as #%d", html.EscapeString(b.mask(w.dst)), m.ID))
+}
+
+// reply answers msg in its chat and topic.
+func (b *Bridge) reply(ctx context.Context, msg *telegramsink.Message, text string, markup interface{}) {
+	d := Destination{Chat: strconv.FormatInt(msg.Chat.ID, 10), Topic: strconv.FormatInt(msg.MessageID, 10)}
+	if _, err := b.send(ctx, d, text, markup); err != nil {
+		log.Printf("Can't reply to Telegram message %d. Error: %s", msg.MessageID, err)
+	}
+}
+
+// editText replaces the text of a bot message, dropping its buttons.
+func (b *Bridge) editText(ctx context.Context, msg *telegramsink.Message, text string) {
+	err := b.call(ctx, "editMessageText", map[string]string{
+		"chat_id":    strconv.FormatInt(msg.Chat.ID, 10),
+		"message_id": strconv.FormatInt(msg.MessageID, 10),
+		"parse_mode": "HTML",
+		"text":       text,
+	}, nil)
+	if err != nil {
+		log.Printf("Can't edit Telegram message %d. Error: %s", msg.MessageID, err)
+	}
+}
@@ -0,0 +1,239 @@
+// Package bridgetest has in-memory fakes of an SMSC and of the Telegram
+// Bot API, to run a bridge or its parts against in tests:
+//
+//	smsc, tg := bridgetest.NewSMSC(), bridgetest.NewTelegram()
+//	b := bridge.New(cfg)
+//	b.SMPP, b.Telegram = smsc.New, tg
+//
+// Both record what they are sent and take injected failures and latency.
+package bridgetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fiorix/go-smpp/smpp"
+	"github.com/fiorix/go-smpp/smpp/pdu"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdufield"
+
+	"telegram-smpp-bot/smppclient"
+)
+
+// SMSC is a fake SMSC. Binds made with New connect at once, submits
+// through them are recorded and get message ids counting up from 1.
+type SMSC struct {
+	// Latency delays every submit.
+	Latency time.Duration
+	// Fail, if set, is asked before every submit and fails it with the
+	// error it returns, like a pdu.Status for a rejected message.
+	Fail func(sm *smpp.ShortMessage) error
+
+	mu        sync.Mutex
+	binds     []*transceiver
+	submitted []Submit
+	lastID    int
+}
+
+// Submit is a message submitted to the fake SMSC.
+type Submit struct {
+	Addr  string // Address of the bind it came through.
+	SM    *smpp.ShortMessage
+	Text  string // The decoded text of SM.
+	Parts int
+	IDs   []string
+}
+
+// NewSMSC returns an SMSC without latency or failures.
+func NewSMSC() *SMSC {
+	return new(SMSC)
+}
+
+// New makes a bind to the fake. It fits Bridge.SMPP.
+func (s *SMSC) New(p smppclient.Params) smppclient.Transceiver {
+	t := &transceiver{smsc: s, p: p}
+	s.mu.Lock()
+	s.binds = append(s.binds, t)
+	s.mu.Unlock()
+	return t
+}
+
+// Submitted returns the messages submitted so far, in order.
+func (s *SMSC) Submitted() []Submit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Submit(nil), s.submitted...)
+}
+
+// Deliver sends an SMS from src to dst through every open bind.
+func (s *SMSC) Deliver(src, dst, text string) {
+	codec, _, _ := smppclient.Encoding(text)
+	s.deliver(src, dst, uint8(codec.Type()), 0x00, codec.Encode())
+}
+
+// DeliverPart sends part seq of total of a multipart SMS, with the 8-bit
+// concatenation reference ref in a user data header before text.
+func (s *SMSC) DeliverPart(src, dst string, ref, total, seq int, text string) {
+	sm := append([]byte{5, 0x00, 3, byte(ref), byte(total), byte(seq)}, text...)
+	s.deliver(src, dst, 0x00, 0x40, sm)
+}
+
+// Receipt sends the delivery receipt of the message with the SMSC id to
+// every open bind, with a state like "DELIVRD" or "UNDELIV".
+func (s *SMSC) Receipt(src, dst, id, state string) {
+	text := fmt.Sprintf("id:%s sub:001 dlvrd:001 submit date:%[2]s done date:%[2]s stat:%s err:000 text:",
+		id, time.Now().Format("0601021504"), state)
+	s.deliver(src, dst, 0x00, 0x04, []byte(text))
+}
+
+// Status reports a connection status, like smpp.Disconnected or
+// smpp.ConnectionFailed, on every open bind.
+func (s *SMSC) Status(id smpp.ConnStatusID) {
+	s.StatusAt("", id)
+}
+
+// StatusAt is Status for the binds to addr only, or for all of them if
+// addr is empty, to take down one of several SMSCs.
+func (s *SMSC) StatusAt(addr string, id smpp.ConnStatusID) {
+	for _, t := range s.open() {
+		if addr == "" || t.p.Addr == addr {
+			t.report(id)
+		}
+	}
+}
+
+func (s *SMSC) deliver(src, dst string, coding, esm uint8, sm []byte) {
+	p := pdu.NewDeliverSM()
+	f := p.Fields()
+	f.Set(pdufield.SourceAddr, src)
+	f.Set(pdufield.DestinationAddr, dst)
+	f.Set(pdufield.DataCoding, coding)
+	f.Set(pdufield.ESMClass, esm)
+	f.Set(pdufield.ShortMessage, sm)
+	// Handlers get what go-smpp decodes off the wire, which differs from
+	// the PDU built here, as in the user data header taken out of
+	// short_message.
+	b, err := smppclient.Serialize(p)
+	if err != nil {
+		panic(err)
+	}
+	if p, err = pdu.Decode(bytes.NewReader(b)); err != nil {
+		panic(err)
+	}
+	for _, t := range s.open() {
+		if t.p.Handler != nil {
+			t.p.Handler(p)
+		}
+	}
+}
+
+func (s *SMSC) open() []*transceiver {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var open []*transceiver
+	for _, t := range s.binds {
+		if t.isOpen() {
+			open = append(open, t)
+		}
+	}
+	return open
+}
+
+type transceiver struct {
+	smsc *SMSC
+	p    smppclient.Params
+
+	mu     sync.Mutex
+	status chan smpp.ConnStatus
+	closed bool
+}
+
+func (t *transceiver) Bind() <-chan smpp.ConnStatus {
+	t.mu.Lock()
+	t.status = make(chan smpp.ConnStatus, 16)
+	t.mu.Unlock()
+	t.report(smpp.Connected)
+	return t.status
+}
+
+func (t *transceiver) Submit(ctx context.Context, sm *smpp.ShortMessage, parts int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !t.isOpen() {
+		return nil, smpp.ErrNotConnected
+	}
+	s := t.smsc
+	if s.Latency > 0 {
+		select {
+		case <-time.After(s.Latency):
+		case <-ctx.Done():
+			// Like a real SMSC, the message is not taken back.
+			defer s.record(t, sm, parts)
+			return nil, fmt.Errorf("%w: %w", smppclient.ErrUnconfirmed, ctx.Err())
+		}
+	}
+	if s.Fail != nil {
+		if err := s.Fail(sm); err != nil {
+			return nil, err
+		}
+	}
+	return s.record(t, sm, parts), nil
+}
+
+// record takes sm and returns its message ids.
+func (s *SMSC) record(t *transceiver, sm *smpp.ShortMessage, parts int) []string {
+	if parts < 1 {
+		parts = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, parts)
+	for i := range ids {
+		s.lastID++
+		ids[i] = strconv.Itoa(s.lastID)
+	}
+	var text string
+	if sm.Text != nil {
+		text = string(sm.Text.Decode())
+	}
+	s.submitted = append(s.submitted, Submit{Addr: t.p.Addr, SM: sm, Text: text, Parts: parts, IDs: ids})
+	return ids
+}
+
+func (t *transceiver) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed && t.status != nil {
+		close(t.status)
+	}
+	t.closed = true
+	return nil
+}
+
+func (t *transceiver) isOpen() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status != nil && !t.closed
+}
+
+// report sends a status unless the bind is closed or nobody reads them.
+func (t *transceiver) report(id smpp.ConnStatusID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || t.status == nil {
+		return
+	}
+	select {
+	case t.status <- connStatus(id):
+	default:
+	}
+}
+
+type connStatus smpp.ConnStatusID
+
+func (c connStatus) Status() smpp.ConnStatusID { return smpp.ConnStatusID(c) }
+func (c connStatus) Error() error              { return nil }
@@ -0,0 +1,167 @@
+package bridgetest
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"telegram-smpp-bot/telegramsink"
+)
+
+// Telegram is a fake Bot API. It records every call, answers sendMessage
+// with the sent message and getUpdates with the updates given to Push.
+// Other methods succeed without a result.
+type Telegram struct {
+	// Latency delays every call, unless its context ends first.
+	Latency time.Duration
+	// Fail, if set, is asked before every call and fails it with the
+	// error it returns, like a *telegramsink.APIError.
+	Fail func(method string) error
+
+	mu      sync.Mutex
+	calls   []Call
+	updates []telegramsink.Update
+	lastMsg int64
+	lastUpd int64
+	wake    chan struct{}
+}
+
+// Call is a Bot API call made to the fake.
+type Call struct {
+	Method string
+	Form   map[string]string
+	Files  map[string]string
+}
+
+// NewTelegram returns a Telegram without latency or failures.
+func NewTelegram() *Telegram {
+	return &Telegram{wake: make(chan struct{}, 1)}
+}
+
+// Calls returns the calls of method made so far, or all calls if method
+// is empty.
+func (t *Telegram) Calls(method string) []Call {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var cs []Call
+	for _, c := range t.calls {
+		if method == "" || c.Method == method {
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+// Push queues an update for getUpdates and returns it with its id set.
+func (t *Telegram) Push(u telegramsink.Update) telegramsink.Update {
+	t.mu.Lock()
+	t.lastUpd++
+	u.UpdateID = t.lastUpd
+	t.updates = append(t.updates, u)
+	t.mu.Unlock()
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+	return u
+}
+
+func (t *Telegram) Call(ctx context.Context, method string, form map[string]string, result interface{}) error {
+	return t.Upload(ctx, method, form, nil, result)
+}
+
+func (t *Telegram) Upload(ctx context.Context, method string, form, files map[string]string, result interface{}) error {
+	if t.Latency > 0 {
+		timer := time.NewTimer(t.Latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if t.Fail != nil {
+		if err := t.Fail(method); err != nil {
+			return err
+		}
+	}
+	t.mu.Lock()
+	t.calls = append(t.calls, Call{Method: method, Form: form, Files: files})
+	t.mu.Unlock()
+
+	var answer interface{}
+	switch method {
+	case "sendMessage":
+		answer = t.sent(form)
+	case "getUpdates":
+		offset, _ := strconv.ParseInt(form["offset"], 10, 64)
+		answer = t.poll(ctx, offset)
+	}
+	if result == nil || answer == nil {
+		return nil
+	}
+	b, err := json.Marshal(answer)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, result)
+}
+
+// Send builds the form the way telegramsink.Client does and calls
+// sendMessage with it.
+func (t *Telegram) Send(ctx context.Context, chat, topic, text string, markup interface{}) (*telegramsink.Message, error) {
+	form := map[string]string{"disable_web_page_preview": "true", "parse_mode": "HTML", "chat_id": chat, "text": text}
+	if topic != "" {
+		form["reply_to_message_id"] = topic
+	}
+	if markup != nil {
+		b, err := json.Marshal(markup)
+		if err != nil {
+			return nil, err
+		}
+		form["reply_markup"] = string(b)
+	}
+	var sent telegramsink.Message
+	if err := t.Call(ctx, "sendMessage", form, &sent); err != nil {
+		return nil, err
+	}
+	return &sent, nil
+}
+
+func (t *Telegram) sent(form map[string]string) *telegramsink.Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastMsg++
+	chat, _ := strconv.ParseInt(form["chat_id"], 10, 64)
+	return &telegramsink.Message{MessageID: t.lastMsg, Chat: telegramsink.Chat{ID: chat}, Text: form["text"]}
+}
+
+// poll returns the queued updates from offset on, waiting for one up to
+// the long poll timeout.
+func (t *Telegram) poll(ctx context.Context, offset int64) []telegramsink.Update {
+	timer := time.NewTimer(telegramsink.PollTimeout * time.Second)
+	defer timer.Stop()
+	for {
+		t.mu.Lock()
+		var us []telegramsink.Update
+		for _, u := range t.updates {
+			if u.UpdateID >= offset {
+				us = append(us, u)
+			}
+		}
+		t.updates = us
+		t.mu.Unlock()
+		if len(us) > 0 {
+			return us
+		}
+		select {
+		case <-t.wake:
+		case <-timer.C:
+			return []telegramsink.Update{}
+		case <-ctx.Done():
+			return []telegramsink.Update{}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Command telegram-smpp-bot runs the bridge with the config in
+// /etc/telegram-smpp/conf.json until it is interrupted.
+//
+//	telegram-smpp-bot replay [-config file] capture
+//
+// feeds a capture made with the capturefile option through the decoder in
+// dry run and prints what would be posted to Telegram.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"telegram-smpp-bot/bridge"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replay(ctx, os.Args[2:])
+		return
+	}
+	cfg, err := bridge.LoadConfig("/etc/telegram-smpp/conf.json")
+	if err != nil {
+		log.Fatalf("Error %s when config read... Stop.", err)
+	}
+	if err := bridge.New(cfg).Run(ctx); err != nil {
+		log.Fatalf("Error %s... Stop.", err)
+	}
+}
+
+func replay(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	path := fs.String("config", "/etc/telegram-smpp/conf.json", "config `file`")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: telegram-smpp-bot replay [-config file] capture")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	cfg, err := bridge.LoadConfig(*path)
+	if err != nil {
+		log.Fatalf("Error %s when config read... Stop.", err)
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Error %s... Stop.", err)
+	}
+	defer f.Close()
+	if err := bridge.Replay(ctx, cfg, f, os.Stdout); err != nil {
+		log.Fatalf("Error %s when replaying %s... Stop.", err, fs.Arg(0))
+	}
+}
@@ -7,4 +7,4 @@ require (
 	golang.org/x/time v0.5.0
 )
 
-require golang.org/x/text v0.3.6 // indirect
+require golang.org/x/text v0.3.6
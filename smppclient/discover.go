@@ -0,0 +1,53 @@
+package smppclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// Resolver looks up host addresses and SRV records. *net.Resolver is one.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// Targets resolves an SMSC address into the addresses to bind to, in the
+// order they should be tried. A host:port gives every address of the host.
+// A name without a port is looked up as a DNS SRV record such as
+// "_smpp._tcp.example.com"; its targets come ordered by priority and
+// shuffled by weight within a priority, as RFC 2782 asks.
+func Targets(ctx context.Context, r Resolver, address string) ([]string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err == nil {
+		addrs, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		targets := make([]string, len(addrs))
+		for i, a := range addrs {
+			targets[i] = net.JoinHostPort(a, port)
+		}
+		return targets, nil
+	}
+
+	_, srvs, err := r.LookupSRV(ctx, "", "", address)
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, srv := range srvs {
+		addrs, err := r.LookupHost(ctx, srv.Target)
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			targets = append(targets, net.JoinHostPort(a, strconv.Itoa(int(srv.Port))))
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no reachable targets in SRV record %s", address)
+	}
+	return targets, nil
+}
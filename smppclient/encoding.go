@@ -0,0 +1,51 @@
+// Package smppclient holds the SMPP specifics of the bridge: choosing the
+// encoding of outbound text, reading the user data header and receipts of
+// inbound deliver_sm, and finding the addresses of an SMSC.
+package smppclient
+
+import (
+	"strings"
+	"unicode/utf16"
+
+	"github.com/fiorix/go-smpp/smpp/pdu/pdutext"
+)
+
+// GSM 03.38 default alphabet and the characters of its extension table,
+// which take two septets each.
+const (
+	gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsm7Ext   = "^{}\\[~]|€\f"
+)
+
+// Encoding names.
+const (
+	EncGSM7 = "GSM-7"
+	EncUCS2 = "UCS-2"
+)
+
+// Encoding picks the encoding text is submitted with and returns the
+// number of parts it takes. Text in the ASCII part of the GSM alphabet is
+// submitted as is in the SMSC default alphabet, anything else as UCS-2.
+func Encoding(text string) (codec pdutext.Codec, enc string, parts int) {
+	units := 0
+	for _, r := range text {
+		if r > 0x7f || !strings.ContainsRune(gsm7Basic+gsm7Ext, r) {
+			units = len(utf16.Encode([]rune(text)))
+			return pdutext.UCS2(text), EncUCS2, countParts(units, 70, 67)
+		}
+		units++
+		if strings.ContainsRune(gsm7Ext, r) {
+			units++
+		}
+	}
+	return pdutext.Raw(text), EncGSM7, countParts(units, 160, 153)
+}
+
+// countParts returns how many parts a message of n units needs, given the
+// capacity of a single message and of each part of a concatenated one.
+func countParts(n, single, multi int) int {
+	if n <= single {
+		return 1
+	}
+	return (n + multi - 1) / multi
+}
@@ -0,0 +1,138 @@
+package smppclient
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/fiorix/go-smpp/smpp/pdu"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdufield"
+	"github.com/fiorix/go-smpp/smpp/pdu/pdutlv"
+)
+
+// IsReceipt reports whether the esm_class of a deliver_sm marks it as an
+// SMSC delivery receipt.
+func IsReceipt(esm []byte) bool {
+	return len(esm) == 1 && esm[0]&0x3c == 0x04
+}
+
+// HasUDH reports whether the esm_class of a deliver_sm says the short
+// message starts with a user data header.
+func HasUDH(esm []byte) bool {
+	return len(esm) == 1 && esm[0]&0x40 != 0
+}
+
+// SplitUDH strips the user data header from sm and returns the
+// concatenation reference, total and sequence number, if it has them.
+func SplitUDH(sm []byte) (body []byte, ref, total, seq int, ok bool) {
+	if len(sm) == 0 || int(sm[0])+1 > len(sm) {
+		return sm, 0, 0, 0, false
+	}
+	udh, body := sm[1:sm[0]+1], sm[sm[0]+1:]
+	for len(udh) >= 2 {
+		id, n := udh[0], int(udh[1])
+		if len(udh) < 2+n {
+			break
+		}
+		ie := udh[2 : 2+n]
+		switch {
+		case id == 0x00 && n == 3: // 8-bit reference.
+			ref, total, seq, ok = int(ie[0]), int(ie[1]), int(ie[2]), true
+		case id == 0x08 && n == 4: // 16-bit reference.
+			ref, total, seq, ok = int(ie[0])<<8|int(ie[1]), int(ie[2]), int(ie[3]), true
+		}
+		udh = udh[2+n:]
+	}
+	if ok && (total < 1 || seq < 1 || seq > total) {
+		ok = false
+	}
+	return body, ref, total, seq, ok
+}
+
+// ParseReceipt extracts the message id and the state from the text of a
+// receipt in the usual "id:123 sub:001 dlvrd:001 ... stat:DELIVRD" format.
+func ParseReceipt(text string) (id, state string) {
+	for _, f := range strings.Fields(text) {
+		k, v, ok := strings.Cut(f, ":")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(k) {
+		case "id":
+			id = v
+		case "stat":
+			state = strings.ToUpper(v)
+		}
+	}
+	return id, state
+}
+
+// UserData returns the short_message of a deliver_sm as it came on the
+// wire. go-smpp moves the user data header of a message with the UDHI
+// flag out of short_message into gsm_user_data; it is put back in front.
+func UserData(f pdufield.Map) []byte {
+	var sm []byte
+	if v := f[pdufield.ShortMessage]; v != nil {
+		sm = v.Bytes()
+	}
+	udh, ok := f[pdufield.GSMUserData].(*pdufield.UDHList)
+	if !ok || len(udh.Data) == 0 {
+		return sm
+	}
+	// Not udh.Bytes: it appends a NUL to each element's data, which
+	// shares its array with short_message and overwrites the text.
+	h := []byte{0}
+	for _, ie := range udh.Data {
+		h = append(h, ie.IEI.Data, byte(len(ie.IEData.Data)))
+		h = append(h, ie.IEData.Data...)
+	}
+	h[0] = byte(len(h) - 1)
+	return append(h, sm...)
+}
+
+// Serialize returns p the way it goes on the wire. go-smpp's SerializeTo
+// doesn't give back what it decoded: it writes a udh_length whether or
+// not the UDHI flag is set and the sm_length without the header.
+func Serialize(p pdu.Body) ([]byte, error) {
+	f := p.Fields()
+	var body bytes.Buffer
+	for _, k := range p.FieldList() {
+		switch k {
+		case pdufield.UDHLength, pdufield.GSMUserData:
+			// Part of short_message.
+			continue
+		case pdufield.SMLength:
+			body.WriteByte(byte(len(UserData(f))))
+			continue
+		case pdufield.ShortMessage:
+			body.Write(UserData(f))
+			continue
+		}
+		v, ok := f[k]
+		if !ok {
+			v = pdufield.New(k, nil)
+		}
+		if err := v.SerializeTo(&body); err != nil {
+			return nil, err
+		}
+	}
+	tlv := p.TLVFields()
+	tags := make([]pdutlv.Tag, 0, len(tlv))
+	for t := range tlv {
+		tags = append(tags, t)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	for _, t := range tags {
+		if err := tlv[t].SerializeTo(&body); err != nil {
+			return nil, err
+		}
+	}
+	h := *p.Header()
+	h.Len = uint32(pdu.HeaderLen + body.Len())
+	var b bytes.Buffer
+	if err := h.SerializeTo(&b); err != nil {
+		return nil, err
+	}
+	b.Write(body.Bytes())
+	return b.Bytes(), nil
+}
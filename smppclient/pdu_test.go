@@ -0,0 +1,151 @@
+package smppclient
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/fiorix/go-smpp/smpp/pdu"
+)
+
+func TestSplitUDH(t *testing.T) {
+	tests := []struct {
+		name            string
+		sm              string
+		body            string
+		ref, total, seq int
+		ok              bool
+	}{
+		{"8-bit reference", "\x05\x00\x03\x2a\x03\x02part", "part", 0x2a, 3, 2, true},
+		{"16-bit reference", "\x06\x08\x04\x12\x34\x02\x01part", "part", 0x1234, 2, 1, true},
+		{"other element first", "\x08\x0a\x01\x00\x00\x03\x07\x02\x02part", "part", 7, 2, 2, true},
+		{"no concatenation", "\x03\x0a\x01\x00part", "part", 0, 0, 0, false},
+		{"sequence past total", "\x05\x00\x03\x2a\x02\x03part", "part", 0x2a, 2, 3, false},
+		{"sequence 0", "\x05\x00\x03\x2a\x02\x00part", "part", 0x2a, 2, 0, false},
+		{"header longer than the message", "\x09\x00\x03", "\x09\x00\x03", 0, 0, 0, false},
+		{"empty", "", "", 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, ref, total, seq, ok := SplitUDH([]byte(tt.sm))
+			if ok != tt.ok || string(body) != tt.body {
+				t.Fatalf("Got %q, %v, want %q, %v", body, ok, tt.body, tt.ok)
+			}
+			if ok && (ref != tt.ref || total != tt.total || seq != tt.seq) {
+				t.Errorf("Got part %d/%d of %d, want %d/%d of %d", seq, total, ref, tt.seq, tt.total, tt.ref)
+			}
+		})
+	}
+}
+
+func TestParseReceipt(t *testing.T) {
+	tests := []struct {
+		text, id, state string
+	}{
+		{"id:0A1B sub:001 dlvrd:001 submit date:2401020304 done date:2401020305 stat:DELIVRD err:000 text:hi", "0A1B", "DELIVRD"},
+		{"ID:42 Stat:undeliv", "42", "UNDELIV"},
+		{"id:7 stat:EXPIRED text:stat:DELIVRD", "7", "EXPIRED"},
+		{"not a receipt", "", ""},
+	}
+	for _, tt := range tests {
+		if id, state := ParseReceipt(tt.text); id != tt.id || state != tt.state {
+			t.Errorf("ParseReceipt(%q) = %q, %q, want %q, %q", tt.text, id, state, tt.id, tt.state)
+		}
+	}
+}
+
+func TestESMClass(t *testing.T) {
+	tests := []struct {
+		esm          []byte
+		receipt, udh bool
+	}{
+		{[]byte{0x00}, false, false},
+		{[]byte{0x04}, true, false},
+		{[]byte{0x40}, false, true},
+		{[]byte{0x44}, true, true},
+		{[]byte{0x08}, false, false}, // Intermediate notification.
+		{nil, false, false},
+	}
+	for _, tt := range tests {
+		if got := IsReceipt(tt.esm); got != tt.receipt {
+			t.Errorf("IsReceipt(%x) = %v, want %v", tt.esm, got, tt.receipt)
+		}
+		if got := HasUDH(tt.esm); got != tt.udh {
+			t.Errorf("HasUDH(%x) = %v, want %v", tt.esm, got, tt.udh)
+		}
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		enc   string
+		parts int
+	}{
+		{"GSM-7", "Hello", EncGSM7, 1},
+		{"GSM-7 full", strings.Repeat("a", 160), EncGSM7, 1},
+		{"GSM-7 two parts", strings.Repeat("a", 161), EncGSM7, 2},
+		{"extension table", strings.Repeat("{", 80), EncGSM7, 1},
+		{"extension table two parts", strings.Repeat("{", 81), EncGSM7, 2},
+		{"not ASCII", "5 €", EncUCS2, 1},
+		{"UCS-2", "Привет", EncUCS2, 1},
+		{"UCS-2 two parts", strings.Repeat("я", 71), EncUCS2, 2},
+		{"emoji", strings.Repeat("😀", 35), EncUCS2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, enc, parts := Encoding(tt.text); enc != tt.enc || parts != tt.parts {
+				t.Errorf("Got %s in %d parts, want %s in %d", enc, parts, tt.enc, tt.parts)
+			}
+		})
+	}
+}
+
+// wireDeliverSM returns a deliver_sm from +491 to TEST as it comes on the
+// wire, with the short message sm and the TLVs tlv.
+func wireDeliverSM(esm byte, sm, tlv string) []byte {
+	body := "\x00" + "\x01\x01+491\x00" + "\x00\x00TEST\x00" + string([]byte{esm, 0, 0}) + "\x00\x00" + string([]byte{1, 0, 0, 0, byte(len(sm))}) + sm + tlv
+	h := make([]byte, 16)
+	binary.BigEndian.PutUint32(h[0:], uint32(16+len(body)))
+	binary.BigEndian.PutUint32(h[4:], uint32(pdu.DeliverSMID))
+	binary.BigEndian.PutUint32(h[12:], 7)
+	return append(h, body...)
+}
+
+func TestSerialize(t *testing.T) {
+	tests := []struct {
+		name string
+		wire []byte
+		data string // User data.
+	}{
+		{"plain", wireDeliverSM(0x00, "hello", ""), "hello"},
+		{"receipt", wireDeliverSM(0x04, "id:1 stat:DELIVRD", ""), "id:1 stat:DELIVRD"},
+		{"8-bit reference", wireDeliverSM(0x40, "\x05\x00\x03\x07\x02\x01hello", ""), "\x05\x00\x03\x07\x02\x01hello"},
+		{"16-bit reference", wireDeliverSM(0x40, "\x06\x08\x04\x12\x34\x02\x02world", ""), "\x06\x08\x04\x12\x34\x02\x02world"},
+		{"message_payload", wireDeliverSM(0x00, "", "\x04\x24\x00\x04long"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := pdu.Decode(bytes.NewReader(tt.wire))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := UserData(p.Fields()); string(got) != tt.data {
+				t.Errorf("Got user data %q, want %q", got, tt.data)
+			}
+			b, err := Serialize(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, tt.wire) {
+				t.Errorf("Got\n%q, want\n%q", b, tt.wire)
+			}
+			// UserData must not have changed the PDU.
+			if b, _ := Serialize(p); !bytes.Equal(b, tt.wire) {
+				t.Errorf("Got\n%q after UserData, want\n%q", b, tt.wire)
+			}
+		})
+	}
+}
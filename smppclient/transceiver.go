@@ -0,0 +1,91 @@
+package smppclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/fiorix/go-smpp/smpp"
+)
+
+// ErrUnconfirmed is returned by Submit, along with the error of ctx, when
+// ctx ended after sm went out but before the SMSC answered. The SMSC may
+// still take the message.
+var ErrUnconfirmed = errors.New("submit unconfirmed")
+
+// Transceiver is a bind to an SMSC that submits and receives messages.
+// New returns one backed by go-smpp; tests can use the fake in bridgetest.
+type Transceiver interface {
+	// Bind connects and keeps the bind up until Close, reporting every
+	// change of the connection status on the returned channel.
+	Bind() <-chan smpp.ConnStatus
+	// Submit sends sm, as a concatenated message if parts is more than
+	// one, and returns the SMSC message ids of the parts. It gives up
+	// waiting for the SMSC when ctx ends, see ErrUnconfirmed.
+	Submit(ctx context.Context, sm *smpp.ShortMessage, parts int) ([]string, error)
+	// Close unbinds and closes the status channel.
+	Close() error
+}
+
+// Params are the settings of a bind.
+type Params struct {
+	Addr       string
+	User       string
+	Passwd     string
+	WindowSize uint             // Max unacknowledged submit_sm PDUs, 0 is unlimited.
+	Handler    smpp.HandlerFunc // Gets the incoming PDUs.
+}
+
+// New returns an unbound go-smpp transceiver for p.
+func New(p Params) Transceiver {
+	return &transceiver{&smpp.Transceiver{
+		Addr:       p.Addr,
+		User:       p.User,
+		Passwd:     p.Passwd,
+		Handler:    p.Handler,
+		WindowSize: p.WindowSize,
+	}}
+}
+
+type transceiver struct {
+	*smpp.Transceiver
+}
+
+func (t *transceiver) Submit(ctx context.Context, sm *smpp.ShortMessage, parts int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	type result struct {
+		ids []string
+		err error
+	}
+	// go-smpp waits for submit_sm_resp on its own timeout; the answer
+	// of an abandoned submit is dropped.
+	done := make(chan result, 1)
+	go func() {
+		ids, err := t.submit(sm, parts)
+		done <- result{ids, err}
+	}()
+	select {
+	case r := <-done:
+		return r.ids, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %w", ErrUnconfirmed, ctx.Err())
+	}
+}
+
+func (t *transceiver) submit(sm *smpp.ShortMessage, parts int) ([]string, error) {
+	if parts <= 1 {
+		resp, err := t.Transceiver.Submit(sm)
+		if err != nil {
+			return nil, err
+		}
+		return []string{resp.RespID()}, nil
+	}
+	resps, err := t.Transceiver.SubmitLongMsg(sm)
+	ids := make([]string, 0, len(resps))
+	for i := range resps {
+		ids = append(ids, resps[i].RespID())
+	}
+	return ids, err
+}
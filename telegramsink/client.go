@@ -0,0 +1,173 @@
+// Package telegramsink is a small Telegram Bot API client: method calls,
+// file uploads and HTML messages.
+package telegramsink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Seconds a getUpdates call waits for new updates.
+const PollTimeout = 50
+
+// Sender makes Bot API calls. *Client is the real one; tests can use the
+// fake in bridgetest.
+type Sender interface {
+	// Call invokes a method and decodes its result into result unless
+	// it is nil.
+	Call(ctx context.Context, method string, form map[string]string, result interface{}) error
+	// Upload is Call with files attached, given as field name to path.
+	Upload(ctx context.Context, method string, form, files map[string]string, result interface{}) error
+	// Send posts an HTML message to chat, replying to topic if it isn't
+	// empty, and returns the sent message.
+	Send(ctx context.Context, chat, topic, text string, markup interface{}) (*Message, error)
+}
+
+// Client calls the Bot API of one bot.
+type Client struct {
+	HTTP        *http.Client  // Keeps connections alive and reused; nil means http.DefaultClient.
+	URL         string        // Bot API server, e.g. https://api.telegram.org.
+	Token       string        // Bot path element, "bot<id>:<key>".
+	ReadTimeout time.Duration // Deadline of a call, long polls get PollTimeout on top.
+	Debug       atomic.Bool   // Log every request with its body, may change while in use.
+}
+
+// Call invokes a Bot API method and decodes its result into result unless
+// it is nil. The call is abandoned when ctx is done.
+func (c *Client) Call(ctx context.Context, method string, form map[string]string, result interface{}) error {
+	return c.Upload(ctx, method, form, nil, result)
+}
+
+// Upload is Call with files attached, given as field name to path.
+func (c *Client) Upload(ctx context.Context, method string, form, files map[string]string, result interface{}) error {
+	apiURL := c.URL + "/" + c.Token + "/" + method
+	ct, body, err := createForm(form, files)
+	if err != nil {
+		return fmt.Errorf("can't build telegram %s form: %w", method, err)
+	}
+
+	if c.Debug.Load() {
+		log.Printf("Telegram API request to URL %s with body: %s", apiURL, body)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout(method))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ct)
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	bodyText, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("can't get answer from Telegram: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		apiErr := &APIError{Code: resp.StatusCode}
+		if json.Unmarshal(bodyText, apiErr) != nil || apiErr.Description == "" {
+			apiErr.Description = string(bodyText)
+		}
+		return apiErr
+	}
+	if result == nil {
+		return nil
+	}
+	var answer struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(bodyText, &answer); err != nil {
+		return fmt.Errorf("can't parse answer from Telegram: %w", err)
+	}
+	return json.Unmarshal(answer.Result, result)
+}
+
+// Send posts an HTML message to chat, replying to topic if it isn't empty,
+// with an optional reply markup such as an inline keyboard and returns the
+// sent message.
+func (c *Client) Send(ctx context.Context, chat, topic, text string, markup interface{}) (*Message, error) {
+	form := map[string]string{"disable_web_page_preview": "true", "parse_mode": "HTML", "chat_id": chat}
+	if topic != "" {
+		form["reply_to_message_id"] = topic
+	}
+	if markup != nil {
+		b, err := json.Marshal(markup)
+		if err != nil {
+			return nil, err
+		}
+		form["reply_markup"] = string(b)
+	}
+
+	form["text"] = text
+	var sent Message
+	if err := c.Call(ctx, "sendMessage", form, &sent); err != nil {
+		return nil, err
+	}
+	return &sent, nil
+}
+
+// timeout returns the deadline of a call. Long polls get their poll time
+// on top of the read timeout.
+func (c *Client) timeout(method string) time.Duration {
+	if method == "getUpdates" {
+		return PollTimeout*time.Second + c.ReadTimeout
+	}
+	return c.ReadTimeout
+}
+
+// APIError is an unsuccessful answer of the Bot API.
+type APIError struct {
+	Code        int    `json:"error_code"`
+	Description string `json:"description"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("unexpected answer from Telegram: %d %s", e.Code, e.Description)
+}
+
+// createForm builds a multipart body of the form fields and of the files,
+// given as field name to path. Files are never taken from form values, so
+// message text can't make the bot upload local files.
+func createForm(form map[string]string, files map[string]string) (string, io.Reader, error) {
+	body := new(bytes.Buffer)
+	mp := multipart.NewWriter(body)
+	defer mp.Close()
+	for key, val := range files {
+		file, err := os.Open(val)
+		if err != nil {
+			return "", nil, err
+		}
+		defer file.Close()
+		part, err := mp.CreateFormFile(key, filepath.Base(val))
+		if err != nil {
+			return "", nil, err
+		}
+		_, err = io.Copy(part, file)
+		if err != nil {
+			log.Printf("Can't copy file %s to part %s. Error: %s", key, val, err)
+		}
+	}
+	for key, val := range form {
+		err := mp.WriteField(key, val)
+		if err != nil {
+			log.Printf("Can't write key %s with value %s to body. Error: %s", key, val, err)
+		}
+	}
+	return mp.FormDataContentType(), body, nil
+}
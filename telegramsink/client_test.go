@@ -0,0 +1,128 @@
+package telegramsink
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// botAPI answers every call with status and body and records the last
+// request's path, form and files.
+type botAPI struct {
+	status int
+	body   string
+	path   string
+	form   map[string]string
+	files  map[string]string // Field name to content.
+}
+
+func (a *botAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.path, a.form, a.files = r.URL.Path, map[string]string{}, map[string]string{}
+	for k, v := range r.MultipartForm.Value {
+		a.form[k] = v[0]
+	}
+	for k, fs := range r.MultipartForm.File {
+		f, _ := fs[0].Open()
+		b, _ := io.ReadAll(f)
+		f.Close()
+		a.files[k] = string(b)
+	}
+	w.WriteHeader(a.status)
+	io.WriteString(w, a.body)
+}
+
+func TestSend(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		topic  string
+		markup interface{}
+		want   *Message
+		err    *APIError
+	}{
+		{"ok", http.StatusOK, `{"ok":true,"result":{"message_id":7,"chat":{"id":-100},"text":"hi"}}`, "", nil,
+			&Message{MessageID: 7, Chat: Chat{ID: -100}, Text: "hi"}, nil},
+		{"topic and markup", http.StatusOK, `{"ok":true,"result":{"message_id":8,"chat":{"id":-100}}}`, "3",
+			InlineKeyboard{InlineKeyboard: [][]InlineButton{{{Text: "Retry", CallbackData: "retry:1"}}}},
+			&Message{MessageID: 8, Chat: Chat{ID: -100}}, nil},
+		{"API error", http.StatusTooManyRequests, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 5"}`, "", nil,
+			nil, &APIError{Code: 429, Description: "Too Many Requests: retry after 5"}},
+		{"not JSON", http.StatusBadGateway, "bad gateway", "", nil,
+			nil, &APIError{Code: 502, Description: "bad gateway"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &botAPI{status: tt.status, body: tt.body}
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+			c := &Client{URL: srv.URL, Token: "bot1:key", ReadTimeout: 5 * time.Second}
+
+			sent, err := c.Send(context.Background(), "-100", tt.topic, "hi", tt.markup)
+			if tt.err != nil {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) || *apiErr != *tt.err {
+					t.Fatalf("Got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *sent != *tt.want {
+				t.Errorf("Got %+v, want %+v", sent, tt.want)
+			}
+			if api.path != "/bot1:key/sendMessage" || api.form["chat_id"] != "-100" || api.form["text"] != "hi" || api.form["parse_mode"] != "HTML" {
+				t.Errorf("Got call %s with %v", api.path, api.form)
+			}
+			if api.form["reply_to_message_id"] != tt.topic {
+				t.Errorf("Got reply_to_message_id %q, want %q", api.form["reply_to_message_id"], tt.topic)
+			}
+			if _, ok := api.form["reply_markup"]; ok != (tt.markup != nil) {
+				t.Errorf("Got reply_markup %q", api.form["reply_markup"])
+			}
+		})
+	}
+}
+
+func TestUpload(t *testing.T) {
+	api := &botAPI{status: http.StatusOK, body: `{"ok":true,"result":true}`}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	c := &Client{URL: srv.URL, Token: "bot1:key", ReadTimeout: 5 * time.Second}
+	cert := filepath.Join(t.TempDir(), "cert.pem")
+	if err := os.WriteFile(cert, []byte("PEM"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A form value naming a file is sent as text, only files are read.
+	err := c.Upload(context.Background(), "setWebhook", map[string]string{"url": "https://bot.example/hook", "certificate2": cert}, map[string]string{"certificate": cert}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if api.files["certificate"] != "PEM" || api.form["certificate2"] != cert || api.form["url"] != "https://bot.example/hook" {
+		t.Errorf("Got form %v and files %v", api.form, api.files)
+	}
+	if err := c.Upload(context.Background(), "setWebhook", nil, map[string]string{"certificate": cert + ".missing"}, nil); err == nil {
+		t.Error("Got no error uploading a missing file")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	c := &Client{ReadTimeout: 5 * time.Second}
+	for method, want := range map[string]time.Duration{"sendMessage": 5 * time.Second, "getUpdates": (PollTimeout + 5) * time.Second} {
+		if got := c.timeout(method); got != want {
+			t.Errorf("timeout(%s) = %s, want %s", method, got, want)
+		}
+	}
+}
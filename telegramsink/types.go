@@ -0,0 +1,43 @@
+package telegramsink
+
+// Bot API types, limited to the fields the bridge uses.
+type (
+	User struct {
+		ID       int64  `json:"id"`
+		Username string `json:"username"`
+	}
+	Chat struct {
+		ID int64 `json:"id"`
+	}
+	Message struct {
+		MessageID int64    `json:"message_id"`
+		From      *User    `json:"from"`
+		Chat      Chat     `json:"chat"`
+		Text      string   `json:"text"`
+		ReplyTo   *Message `json:"reply_to_message"`
+	}
+	CallbackQuery struct {
+		ID      string   `json:"id"`
+		From    User     `json:"from"`
+		Message *Message `json:"message"`
+		Data    string   `json:"data"`
+	}
+	Update struct {
+		UpdateID      int64          `json:"update_id"`
+		Message       *Message       `json:"message"`
+		CallbackQuery *CallbackQuery `json:"callback_query"`
+	}
+	InlineKeyboard struct {
+		InlineKeyboard [][]InlineButton `json:"inline_keyboard"`
+	}
+	InlineButton struct {
+		Text         string `json:"text"`
+		CallbackData string `json:"callback_data"`
+	}
+	// ForceReply asks the user to answer the bot's message.
+	ForceReply struct {
+		ForceReply bool   `json:"force_reply"`
+		Selective  bool   `json:"selective"`
+		Hint       string `json:"input_field_placeholder,omitempty"`
+	}
+)